@@ -4,4 +4,6 @@ go 1.20
 
 require github.com/andrew-d/go-termutil v0.0.0-20150726205930-009166a695a2
 
-require github.com/spf13/pflag v1.0.5 // indirect
+require github.com/spf13/pflag v1.0.5
+
+require golang.org/x/text v0.14.0
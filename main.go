@@ -4,12 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/andrew-d/go-termutil"
 	"github.com/dark-enstein/tr/pkg/r"
@@ -45,7 +45,6 @@ func main() {
 			}
 		}
 		if len(f.SqueezeString) > 0 {
-			fmt.Println("enter squeeze:", f.SqueezeString)
 			if f.Action >= 0 {
 				f.Action = r.Action_SQUEEZE
 				f.SqueezeBytes = []byte(f.SqueezeString)
@@ -54,6 +53,10 @@ func main() {
 				return
 			}
 		}
+		if f.DumpScript {
+			dumpScript(&f)
+			return
+		}
 		_main(&f, ctx)
 	case false:
 		initFlags(&f)
@@ -82,103 +85,319 @@ func main() {
 // initFlags initializes the flags defined at start time
 func initFlags(f *r.Flags) {
 	pflag.StringVarP(&f.DelString, "delete", "d",
-		"90808", "delete all occurrence of a string in input text")
+		"", "delete all occurrence of a string in input text")
 	pflag.StringVarP(&f.SqueezeString, "squeeze", "s",
-		"", "EXPERIMENTAL: reduce all repeated char occurence of any of char"+
-			" in value"+
-			" string in input text (doesn't work as intended)")
+		"", "reduce all repeated char occurence of any of char in value"+
+			" string in input text")
+	pflag.BoolVarP(&f.Complement, "complement", "c", false,
+		"use the complement of SET1")
+	pflag.StringVar(&f.Within, "within", "",
+		"only process the parts of each line matching this regex")
+	pflag.StringVar(&f.Outside, "outside", "",
+		"only process the parts of each line not matching this regex")
+	pflag.StringVar(&f.Group, "group", "",
+		"named capture group of --within/--outside delimiting the scope")
+	pflag.StringVar(&f.Lines, "lines", "",
+		"only process this range of lines, eg: 1,10 or 5,$. with --csv, "+
+			"a record is addressed by the line it starts on")
+	pflag.StringVar(&f.Match, "match", "",
+		"only process lines matching this regex")
+	pflag.StringVar(&f.NotMatch, "not-match", "",
+		"only process lines not matching this regex")
+	pflag.StringVar(&f.Fields, "fields", "",
+		"only process this list of columns, eg: 1,3-5")
+	pflag.StringVar(&f.Delimiter, "delimiter", "",
+		"column delimiter for --fields (default tab, or comma with --csv)")
+	pflag.BoolVar(&f.CSV, "csv", false,
+		"parse columns as RFC 4180 CSV, preserving quoting")
+	pflag.BoolVar(&f.JSON, "json", false,
+		"only process the string values of a JSON document")
+	pflag.BoolVar(&f.JSONL, "jsonl", false,
+		"only process the string values of newline delimited JSON")
+	pflag.BoolVar(&f.JSONKeys, "json-keys", false,
+		"also process object keys in --json/--jsonl mode")
+	pflag.StringSliceVar(&f.JSONPath, "json-path", nil,
+		"only process the values at these paths, eg: $.items.*.name")
+	pflag.StringVar(&f.Stats, "stats", "",
+		"report statistics on stderr in `FORMAT`, human (default) or json. "+
+			"a FORMAT must be attached, as in --stats=json")
+	pflag.Lookup("stats").NoOptDefVal = r.StatsHuman
+	pflag.BoolVar(&f.Diff, "diff", false,
+		"preview the changes as a unified diff instead of writing the result")
+	pflag.BoolVar(&f.Highlight, "highlight", false,
+		"preview the result with changed and deleted characters colourised")
+	pflag.BoolVar(&f.Count, "count", false,
+		"print how many characters of the input are in SET1")
+	pflag.BoolVar(&f.Check, "check", false,
+		"list the characters that would be changed, exiting 1 if any")
+	pflag.IntVar(&f.CheckLimit, "check-limit", 10,
+		"list at most this many characters with --check, -1 for all")
+	pflag.StringVar(&f.Sanitize, "sanitize", "",
+		"strip escape sequences and control characters. options: ansi, "+
+			"controls[=strip|caret|hex|picture], utf8")
+	pflag.Lookup("sanitize").NoOptDefVal = "ansi,controls"
+	pflag.StringVar(&f.Mask, "mask", "",
+		"mask the email, card, ipv4, ipv6, token and aws data of the output, "+
+			"keeping its format or with a fixed token, eg: all,email=<email>")
+	pflag.Lookup("mask").NoOptDefVal = r.MaskAll
+	pflag.StringVar(&f.EOL, "eol", "",
+		"normalise line endings to lf, crlf, cr or auto (native)")
+	pflag.BoolVar(&f.DetectEOL, "detect-eol", false,
+		"print the mix of line endings found in the input")
+	pflag.StringVar(&f.Normalize, "normalize", "",
+		"normalize the input to nfc, nfd, nfkc or nfkd")
+	pflag.StringVar(&f.NormalizeOutput, "normalize-output", "",
+		"normalize the output to nfc, nfd, nfkc or nfkd")
+	pflag.BoolVar(&f.Fold, "fold", false,
+		"apply full Unicode case folding to the input")
+	pflag.StringVar(&f.Case, "case", "",
+		"map the output to upper, lower or title case")
+	pflag.BoolVar(&f.ToASCII, "to-ascii", false,
+		"transliterate the output to plain ASCII")
+	pflag.StringVar(&f.ASCIIReplacement, "ascii-replacement", "?",
+		"replacement for characters --to-ascii can't transliterate")
+	pflag.BoolVar(&f.ASCIIReport, "to-ascii-report", false,
+		"report the characters --to-ascii couldn't transliterate on stderr")
+	pflag.StringVar(&f.Escape, "escape", "",
+		"escape the input ahead of the operation: c, json, url, html or "+
+			"shell. json replaces invalid UTF-8 with \\uFFFD")
+	pflag.StringVar(&f.Unescape, "unescape", "",
+		"unescape the output: c, json, url, html or shell")
+	pflag.StringVar(&f.ShowNonPrinting, "show-nonprinting", "",
+		"show the bytes of the output as ^X and M-x (caret), or \\xNN (hex), "+
+			"with line ends as $")
+	pflag.Lookup("show-nonprinting").NoOptDefVal = r.ControlsCaret
+	pflag.BoolVar(&f.IgnoreCase, "ignore-case", false,
+		"replace SET1 as a string with SET2, ignoring case")
+	pflag.BoolVar(&f.Word, "word", false,
+		"replace SET1 as a string with SET2, at word boundaries only")
+	pflag.BoolVar(&f.PreserveCase, "preserve-case", false,
+		"replace SET1 as a string with SET2 in the case of the occurrence")
+	pflag.StringVar(&f.Regex, "regex", "",
+		"replace the matches of a regular expression with --replace, ahead "+
+			"of the operation and on the same lines, scopes and fields")
+	pflag.StringVar(&f.Replace, "replace", "",
+		"template replacing the matches of --regex, where $1 or ${name} "+
+			"stand for capture groups")
+	pflag.StringVarP(&f.Script, "script", "f", "",
+		"run the operations listed in a script FILE in turn, one per line, "+
+			"eg: translate a-z A-Z")
+	pflag.StringArrayVar(&f.Stages, "stage", nil,
+		"run a registered operation ahead of the operation, written like a "+
+			"line of a -f script, eg: 'replace foo bar'. repeatable")
+	pflag.StringArrayVar(&f.Plugins, "plugin", nil,
+		"run the input through an external COMMAND ahead of the operation, "+
+			"as length-prefixed frames on its stdin and stdout. repeatable")
+	pflag.DurationVar(&f.PluginTimeout, "plugin-timeout",
+		r.DefaultPluginTimeout, "how long a --plugin has to answer a frame")
+	pflag.BoolVar(&f.DumpScript, "dump-script", false,
+		"print the operations of the -f script and --stage as compiled "+
+			"and exit")
+	pflag.IntVar(&f.Max, "max", 0,
+		"stop after N translations, deletions or replacements")
+	pflag.BoolVar(&f.PerLine, "per-line", false,
+		"apply --max to every line rather than in total")
+	pflag.IntVar(&f.Occurrence, "occurrence", 0,
+		"only act on the K-th match of every line")
+	pflag.StringVar(&f.Locale, "locale", r.DefaultLocale(),
+		"locale of the character classes and case mappings, eg: tr_TR. C "+
+			"and POSIX work byte by byte")
+	pflag.StringVar(&f.InputEncoding, "input-encoding", "",
+		"decode the input from an encoding, eg: latin1, windows-1252, "+
+			"IBM037, utf-16")
+	pflag.StringVar(&f.OutputEncoding, "output-encoding", "",
+		"encode the output into an encoding, eg: latin1, windows-1252, "+
+			"IBM037, utf-16le")
+	pflag.StringVar(&f.Charmap, "charmap", "",
+		"decode the input from an 8-bit charmap FILE, either POSIX or a "+
+			"table of 0xNN<TAB>U+XXXX lines")
+	pflag.StringVar(&f.Unencodable, "unencodable", r.UnencodableError,
+		"what becomes of characters --output-encoding can't encode: error, "+
+			"replace or skip")
 	pflag.Parse()
+	if f.Stats != "" {
+		if err := r.CheckStatsFormat(f.Stats); err != nil {
+			log.Printf("error parsing --stats: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
 }
 
 func _main(f *r.Flags, ctx context.Context) {
-	rep := r.R{}
-	var arg []string
-	by, class := whichClass()
-	if len(f.DelString) > 1 {
-		rep.FlagEnabled = true
-		rep.Flag = f
-	}
-	if len(f.SqueezeString) > 1 {
-		rep.FlagEnabled = true
-		rep.Flag = f
-	}
+	rep := r.R{Flag: f, FlagEnabled: f.Action != 0}
+	in, class := whichClass()
 	switch class {
 	case CONSOLE:
 		//fmt.Println("enter console")
-		arg = os.Args[1:]
 		b := OpenConsole()
 		rep.RawBytes = b
 		rep.RawString = string(b)
-		rep.From = []byte(arg[0])
-		rep.To = []byte(arg[1])
+		rep.From, rep.To = setArgs(f, pflag.Args())
 		rep.Churn(ctx)
 		w.Write(rep.DestString)
 		_main(f, ctx)
 	case STDIN:
 		//fmt.Println("enter stdin")
-		arg = os.Args[1:]
-		if len(arg) != 2 {
-			log.Printf("expecting two arguments. got: %v\n", arg)
-			os.Exit(1)
-		}
-		rep.RawBytes = by
-		rep.RawString = string(by)
-		rep.From = []byte(arg[0])
-		rep.To = []byte(arg[1])
-		rep.Churn(ctx)
-		w.Write(rep.DestString)
+		rep.From, rep.To = setArgs(f, pflag.Args())
+		stream(ctx, &rep, "-", in)
 	case FILE:
 		//fmt.Println("enter file")
-		fileName, err := filepath.Abs(os.Args[1])
+		arg := pflag.Args()
+		if len(arg) == 0 {
+			log.Println("expecting a file to read from")
+			os.Exit(1)
+		}
+		fileName, err := filepath.Abs(arg[0])
 		if err != nil {
 			log.Printf("err with expanding file path: %s\n", err.Error())
 			os.Exit(1)
 		}
-		_, err = os.Stat(fileName)
+		file, err := os.Open(fileName)
 		if os.IsNotExist(err) {
 			log.Printf("err file doesn't exist: %s\n",
 				err.Error())
 			os.Exit(1)
 		}
-		fileB, err := os.ReadFile(fileName)
 		if err != nil {
 			log.Printf("err with reading file: %s\n", err.Error())
 			os.Exit(1)
 		}
-		rep.RawBytes = fileB
-		rep.RawString = string(fileB)
-		arg = os.Args[2:]
-		if len(arg) != 2 {
-			log.Printf("expecting two arguments. got: %v\n", arg)
+		defer file.Close()
+		rep.From, rep.To = setArgs(f, arg[1:])
+		stream(ctx, &rep, arg[0], file)
+	}
+	return
+}
+
+// setArgs returns SET1 and SET2 from the positional arguments in arg. They
+// may only be left out when the flags already define what to do.
+func setArgs(f *r.Flags, arg []string) ([]byte, []byte) {
+	switch {
+	case len(arg) == 2:
+		return []byte(arg[0]), []byte(arg[1])
+	case len(arg) == 0 && f.Standalone():
+		return nil, nil
+	}
+	log.Printf("expecting two arguments. got: %v\n", arg)
+	os.Exit(1)
+	return nil, nil
+}
+
+// dumpScript prints the operations of the script and --stage selected by f
+// as compiled, exiting 1 if they don't compile
+func dumpScript(f *r.Flags) {
+	if f.Script == "" && len(f.Stages) == 0 {
+		log.Println("expecting a script to dump, see -f and --stage")
+		os.Exit(1)
+	}
+	loc, err := r.ParseLocale(f.Locale)
+	if err == nil {
+		err = f.DumpOps(os.Stdout, &r.Env{Locale: loc})
+	}
+	if err != nil {
+		log.Printf("error dumping script: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// stream runs rep over everything read from in, the input named name,
+// writing the result to stdout
+func stream(ctx context.Context, rep *r.R, name string, in io.Reader) {
+	if rep.Flag.Stats != "" || rep.Flag.ASCIIReport {
+		rep.Stats = r.NewStats()
+	}
+	var err error
+	switch {
+	case rep.Flag.Count:
+		var count int64
+		if count, err = rep.Count(in); err == nil {
+			fmt.Printf("%s:%d\n", name, count)
+		}
+	case rep.Flag.DetectEOL:
+		var counts r.EOLCounts
+		if counts, err = r.DetectEOL(in); err == nil {
+			fmt.Printf("%s: %s\n", name, counts)
+		}
+	case rep.Flag.Check:
+		err = check(rep, name, in)
+	case rep.Flag.Diff || rep.Flag.Highlight:
+		err = preview(ctx, rep, name, in)
+	default:
+		err = rep.Stream(ctx, in, os.Stdout)
+	}
+	if err != nil {
+		log.Printf("error processing input: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if rep.Flag.ASCIIReport {
+		unmapped := make([]string, 0, len(rep.Stats.Unmapped))
+		for c := range rep.Stats.Unmapped {
+			unmapped = append(unmapped, c)
+		}
+		sort.Strings(unmapped)
+		for _, c := range unmapped {
+			fmt.Fprintf(os.Stderr, "unmapped: %s (%d)\n", c,
+				rep.Stats.Unmapped[c])
+		}
+	}
+	if rep.Flag.Stats != "" {
+		if err := rep.Stats.Report(os.Stderr, rep.Flag.Stats); err != nil {
+			log.Printf("error reporting stats: %s\n", err.Error())
 			os.Exit(1)
 		}
-		rep.From = []byte(arg[0])
-		rep.To = []byte(arg[1])
-		rep.Churn(ctx)
-		w.Write(rep.DestString)
 	}
-	return
 }
 
-func whichClass() ([]byte, int) {
-	byt, err := []byte{}, errors.New("")
+// check lists the characters read from in, the input named name, that rep
+// would change, exiting 1 if there are any
+func check(rep *r.R, name string, in io.Reader) error {
+	offenders, total, err := rep.Check(in, rep.Flag.CheckLimit)
+	if err != nil {
+		return err
+	}
+	for _, o := range offenders {
+		fmt.Printf("%s:%d:%d: %q\n", name, o.Line, o.Col, o.Match)
+	}
+	if total > int64(len(offenders)) {
+		fmt.Printf("%s: %d more\n", name, total-int64(len(offenders)))
+	}
+	if total > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// preview runs rep over everything read from in, the input named name, and
+// writes out how the result differs from the input rather than the result
+// itself
+func preview(ctx context.Context, rep *r.R, name string, in io.Reader) error {
+	orig, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	var res bytes.Buffer
+	if err = rep.Stream(ctx, bytes.NewReader(orig), &res); err != nil {
+		return err
+	}
+	if rep.Flag.Diff {
+		return w.UnifiedDiff(os.Stdout, name, orig, res.Bytes())
+	}
+	return w.Highlight(os.Stdout, orig, res.Bytes(),
+		termutil.Isatty(os.Stdout.Fd()))
+}
+
+func whichClass() (io.Reader, int) {
 	switch termutil.Isatty(os.Stdin.Fd()) {
 	case true:
-		if !(len(os.Args) == 3) {
+		if !(pflag.NArg() == 2) {
 			log.Println("no stdin")
-			return []byte(""), FILE
+			return nil, FILE
 		} else {
-			return []byte(""), CONSOLE
-		}
-	case false:
-		//log.Println("something in stdin")
-		byt, err = io.ReadAll(os.Stdin)
-		if err != nil {
-			log.Printf("error encountered reading Stdin: %s\n", err.Error())
+			return nil, CONSOLE
 		}
-		byt = bytes.TrimSpace(byt)
 	}
-	return byt, STDIN
+	//log.Println("something in stdin")
+	return os.Stdin, STDIN
 }
 
 func _mainDebug(f *r.Flags, ctx context.Context) string {
@@ -187,7 +406,7 @@ func _mainDebug(f *r.Flags, ctx context.Context) string {
 		[]byte("a-z")
 	rep.Churn(ctx)
 	var arg []string
-	by, class := whichClass()
+	in, class := whichClass()
 	if len(f.DelString) > 1 {
 		rep.FlagEnabled = true
 		rep.Flag = f
@@ -215,6 +434,7 @@ func _mainDebug(f *r.Flags, ctx context.Context) string {
 			log.Printf("expecting two arguments. got: %v\n", arg)
 			os.Exit(1)
 		}
+		by, _ := io.ReadAll(in)
 		rep.RawBytes = by
 		rep.RawString = string(by)
 		rep.From = []byte(arg[0])
@@ -0,0 +1,85 @@
+package r
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Address selects the lines of the input that get processed, in the manner
+// of sed addresses. Lines not selected pass through unchanged.
+type Address struct {
+	// First and Last are the 1-based bounds of the selected line range. Last
+	// is -1 when the range runs to the end of the input ($), and both are 0
+	// when no range was given.
+	First, Last int
+	// Match, if set, must match a line for it to be selected
+	Match *regexp.Regexp
+	// NotMatch, if set, must not match a line for it to be selected
+	NotMatch *regexp.Regexp
+}
+
+// ParseLines parses a line range in the form N, N,M or N,$ into a.
+func (a *Address) ParseLines(s string) error {
+	first, last, found := strings.Cut(s, ",")
+	var err error
+	if a.First, err = strconv.Atoi(first); err != nil || a.First < 1 {
+		return fmt.Errorf("err: invalid start line in range %q", s)
+	}
+	switch {
+	case !found:
+		a.Last = a.First
+	case last == "$":
+		a.Last = -1
+	default:
+		if a.Last, err = strconv.Atoi(last); err != nil || a.Last < a.First {
+			return fmt.Errorf("err: invalid end line in range %q", s)
+		}
+	}
+	return nil
+}
+
+// Selects reports whether line, the n-th line of the input, is addressed.
+func (a *Address) Selects(n int, line []byte) bool {
+	if a.First > 0 && (n < a.First || (a.Last >= 0 && n > a.Last)) {
+		return false
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if a.Match != nil && !a.Match.Match(line) {
+		return false
+	}
+	if a.NotMatch != nil && a.NotMatch.Match(line) {
+		return false
+	}
+	return true
+}
+
+// address builds the Address configured by the Lines/Match/NotMatch flags,
+// returning nil if none of them is set.
+func (f *Flags) address() (*Address, error) {
+	if f == nil || (f.Lines == "" && f.Match == "" && f.NotMatch == "") {
+		return nil, nil
+	}
+	a := &Address{}
+	var err error
+	if f.Lines != "" {
+		if err = a.ParseLines(f.Lines); err != nil {
+			return nil, err
+		}
+	}
+	if f.Match != "" {
+		if a.Match, err = regexp.Compile(f.Match); err != nil {
+			return nil, fmt.Errorf("err: could not compile --match regex"+
+				" %q: %w", f.Match, err)
+		}
+	}
+	if f.NotMatch != "" {
+		if a.NotMatch, err = regexp.Compile(f.NotMatch); err != nil {
+			return nil, fmt.Errorf("err: could not compile --not-match regex"+
+				" %q: %w", f.NotMatch, err)
+		}
+	}
+	return a, nil
+}
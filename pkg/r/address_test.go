@@ -0,0 +1,73 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+)
+
+func TestParseLines(t *testing.T) {
+	test := []struct {
+		Lines       string
+		First, Last int
+		Err         bool
+	}{
+		{"1,10", 1, 10, false},
+		{"5,$", 5, -1, false},
+		{"3", 3, 3, false},
+		{"0,4", 0, 0, true},
+		{"4,2", 0, 0, true},
+		{"a,$", 0, 0, true},
+	}
+	for i := 0; i < len(test); i++ {
+		a := Address{}
+		err := a.ParseLines(test[i].Lines)
+		if (err != nil) != test[i].Err {
+			t.Errorf("%s: expected error %v. got %v\n", test[i].Lines,
+				test[i].Err, err)
+			continue
+		}
+		if err == nil && (a.First != test[i].First || a.Last != test[i].Last) {
+			t.Errorf("%s: expected %d,%d. got %d,%d\n", test[i].Lines,
+				test[i].First, test[i].Last, a.First, a.Last)
+		}
+	}
+}
+
+func TestAddressSelects(t *testing.T) {
+	a := Address{First: 2, Last: -1, Match: regexp.MustCompile(`^#`),
+		NotMatch: regexp.MustCompile(`skip`)}
+	test := []struct {
+		N    int
+		Line string
+		Want bool
+	}{
+		{1, "# header\n", false},
+		{2, "# header\n", true},
+		{3, "body\n", false},
+		{4, "# skip me\n", false},
+		{100, "#\n", true},
+	}
+	for i := 0; i < len(test); i++ {
+		if got := a.Selects(test[i].N, []byte(test[i].Line)); got != test[i].Want {
+			t.Errorf("line %d %q: expected %v. got %v\n", test[i].N,
+				test[i].Line, test[i].Want, got)
+		}
+	}
+}
+
+func TestStreamLines(t *testing.T) {
+	r := R{From: []byte("a-z"), To: []byte("A-Z"),
+		Flag: &Flags{Lines: "2,$", NotMatch: "keep"}}
+	var out bytes.Buffer
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("head\nbody\nkeep\ntail\n"), &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "head\nBODY\nkeep\nTAIL\n" {
+		t.Errorf("expected %q. got %q\n", "head\nBODY\nkeep\nTAIL\n",
+			out.String())
+	}
+}
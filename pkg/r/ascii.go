@@ -0,0 +1,104 @@
+package r
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// asciiFallbacks transliterates the characters that don't decompose into
+// ASCII on their own.
+var asciiFallbacks = map[rune]string{
+	'ß': "ss", 'ẞ': "SS", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE",
+	'ø': "o", 'Ø': "O", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th",
+	'Þ': "Th", 'ł': "l", 'Ł': "L", 'ħ': "h", 'Ħ': "H", 'ı': "i", 'ŋ': "ng",
+	'Ŋ': "NG", 'ĸ': "q",
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'",
+	'“': `"`, '”': `"`, '„': `"`, '‟': `"`, '″': `"`,
+	'‹': "<", '›': ">", '«': "<<", '»': ">>",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'•': "*", '·': ".", '×': "x", '÷': "/", '⁄': "/",
+	'€': "EUR", '£': "GBP", '©': "(C)", '®': "(R)",
+}
+
+// Transliterator is a Stage folding its input to plain ASCII. Characters
+// are decomposed, their combining marks dropped, and whatever is left
+// outside of ASCII looked up in a table of fallbacks. Characters still
+// unmapped after that are replaced by Replacement.
+type Transliterator struct {
+	// Replacement stands in for characters that can't be transliterated
+	Replacement string
+	// Stats, if set, counts the characters that couldn't be transliterated
+	Stats *Stats
+	held  []byte
+}
+
+// Process implements Stage.
+func (t *Transliterator) Process(b []byte) []byte {
+	b = append(t.held, b...)
+	t.held = nil
+	buffer := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		c, size := utf8.DecodeRune(b[i:])
+		if c == utf8.RuneError && size == 1 && !utf8.FullRune(b[i:]) {
+			t.held = append(t.held, b[i:]...)
+			break
+		}
+		buffer = t.appendASCII(buffer, c, c == utf8.RuneError && size == 1)
+		i += size
+	}
+	return buffer
+}
+
+// Flush implements Stage.
+func (t *Transliterator) Flush() []byte {
+	var buffer []byte
+	for range t.held {
+		buffer = t.appendASCII(buffer, utf8.RuneError, true)
+	}
+	t.held = nil
+	return buffer
+}
+
+// appendASCII appends the transliteration of c to buffer. invalid marks a
+// byte that isn't valid UTF-8.
+func (t *Transliterator) appendASCII(buffer []byte, c rune,
+	invalid bool) []byte {
+	if c < utf8.RuneSelf && !invalid {
+		return append(buffer, byte(c))
+	}
+	if s, ok := transliterate(c); ok && !invalid {
+		return append(buffer, s...)
+	}
+	if t.Stats != nil {
+		key := "invalid UTF-8"
+		if !invalid {
+			key = fmt.Sprintf("U+%04X %c", c, c)
+		}
+		t.Stats.Unmapped[key]++
+	}
+	return append(buffer, t.Replacement...)
+}
+
+// transliterate returns the ASCII transliteration of c, if it has one.
+func transliterate(c rune) (string, bool) {
+	if s, ok := asciiFallbacks[c]; ok {
+		return s, true
+	}
+	var buffer []byte
+	for _, d := range norm.NFKD.String(string(c)) {
+		switch s, ok := asciiFallbacks[d]; {
+		case d < utf8.RuneSelf:
+			buffer = append(buffer, byte(d))
+		case unicode.Is(unicode.Mn, d):
+			// combining marks are dropped
+		case ok:
+			buffer = append(buffer, s...)
+		default:
+			return "", false
+		}
+	}
+	return string(buffer), true
+}
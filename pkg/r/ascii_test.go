@@ -0,0 +1,48 @@
+package r
+
+import (
+	"testing"
+)
+
+func TestTransliterator(t *testing.T) {
+	test := []struct {
+		RawString  string
+		DestString string
+	}{
+		{"Crème brûlée", "Creme brulee"},
+		{"Straße", "Strasse"},
+		{"“smart” ‘quotes’", `"smart" 'quotes'`},
+		{"a — b – c", "a - b - c"},
+		{"Œuvre, Ærø, Łódź", "OEuvre, AEro, Lodz"},
+		{"wait…", "wait..."},
+		{"ﬁ ½", "fi 1/2"},
+		{"中x", "?x"},
+		{"bad\xffbyte", "bad?byte"},
+		{"plain ascii", "plain ascii"},
+	}
+	for i := 0; i < len(test); i++ {
+		for _, size := range []int{1, 64} {
+			tr := &Transliterator{Replacement: "?"}
+			got := runStage(tr, []byte(test[i].RawString), size)
+			if string(got) != test[i].DestString {
+				t.Errorf("%d: expected %q. got %q\n", size,
+					test[i].DestString, got)
+			}
+		}
+	}
+}
+
+func TestTransliteratorUnmapped(t *testing.T) {
+	tr := &Transliterator{Replacement: "_", Stats: NewStats()}
+	got := runStage(tr, []byte("中文中\xff"), 2)
+	if string(got) != "____" {
+		t.Errorf("expected %q. got %q\n", "____", got)
+	}
+	want := map[string]int64{"U+4E2D 中": 2, "U+6587 文": 1,
+		"invalid UTF-8": 1}
+	for k, v := range want {
+		if tr.Stats.Unmapped[k] != v {
+			t.Errorf("expected %v. got %v\n", want, tr.Stats.Unmapped)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package r
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Charmap is a site-specific 8-bit code page, mapping every byte to the
+// character it stands for.
+type Charmap struct {
+	// Name is the code set name the charmap gives, or its file name
+	Name string
+	// Runes holds the character of every byte. Bytes left undefined are
+	// utf8.RuneError.
+	Runes [256]rune
+}
+
+// LoadCharmap loads the charmap held in the file at path. See ParseCharmap.
+func LoadCharmap(path string) (*Charmap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("err: can't open charmap: %w", err)
+	}
+	defer file.Close()
+	c, err := ParseCharmap(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Name == "" {
+		c.Name = path
+	}
+	return c, nil
+}
+
+// ParseCharmap parses a charmap, written either in the POSIX charmap format
+// (as found in /usr/share/i18n/charmaps), or as a table of a byte and the
+// character it stands for per line, eg:
+//
+//	0x80	U+20AC	# EURO SIGN
+//
+// where the character may also be written 0x20AC, as in the mapping tables
+// published by Unicode.
+func ParseCharmap(in io.Reader) (*Charmap, error) {
+	var lines []string
+	posix := false
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+		posix = posix || strings.TrimSpace(sc.Text()) == "CHARMAP"
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	c := &Charmap{}
+	for i := range c.Runes {
+		c.Runes[i] = utf8.RuneError
+	}
+	if posix {
+		return c, c.parsePOSIX(lines)
+	}
+	return c, c.parseTable(lines)
+}
+
+// parseTable parses the lines of a table of bytes and characters.
+func (c *Charmap) parseTable(lines []string) error {
+	for n, line := range lines {
+		line, _, _ = strings.Cut(line, "#")
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		b, err := strconv.ParseUint(strings.TrimPrefix(f[0], "0x"), 16, 8)
+		if err != nil || !strings.HasPrefix(f[0], "0x") {
+			return fmt.Errorf("err: line %d: bad byte %q", n+1, f[0])
+		}
+		if len(f) == 1 {
+			// undefined, as in the tables published by Unicode
+			continue
+		}
+		hex := strings.TrimPrefix(strings.TrimPrefix(f[1], "U+"), "0x")
+		r, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || hex == f[1] || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("err: line %d: bad character %q", n+1, f[1])
+		}
+		c.Runes[b] = rune(r)
+	}
+	return nil
+}
+
+// parsePOSIX parses the lines of a POSIX charmap. Characters must be named
+// by their code point (<U20AC>), and every one of them must take up a single
+// byte.
+func (c *Charmap) parsePOSIX(lines []string) error {
+	comment, escape := "%", "/"
+	inMap := false
+	for n, line := range lines {
+		f := strings.Fields(line)
+		switch {
+		case len(f) == 0 || strings.HasPrefix(f[0], comment):
+			continue
+		case !inMap && len(f) == 2 && f[0] == "<code_set_name>":
+			c.Name = f[1]
+		case !inMap && len(f) == 2 && f[0] == "<comment_char>":
+			comment = f[1]
+		case !inMap && len(f) == 2 && f[0] == "<escape_char>":
+			escape = f[1]
+		case !inMap:
+			inMap = f[0] == "CHARMAP"
+		case f[0] == "END" && len(f) > 1 && f[1] == "CHARMAP":
+			return nil
+		case len(f) < 2:
+			return fmt.Errorf("err: line %d: missing byte", n+1)
+		default:
+			if err := c.addPOSIX(f[0], f[1], escape); err != nil {
+				return fmt.Errorf("err: line %d: %w", n+1, err)
+			}
+		}
+	}
+	return fmt.Errorf("err: missing END CHARMAP")
+}
+
+// addPOSIX adds the character, or range of characters, named by sym and
+// encoded as enc.
+func (c *Charmap) addPOSIX(sym, enc, escape string) error {
+	lo, hi, isRange := strings.Cut(sym, "..")
+	first, err := posixSymbol(lo)
+	if err != nil {
+		return err
+	}
+	last := first
+	if isRange {
+		if last, err = posixSymbol(hi); err != nil {
+			return err
+		}
+	}
+	b, err := posixByte(enc, escape)
+	if err != nil {
+		return err
+	}
+	if int(b)+int(last-first) > 0xff || last < first {
+		return fmt.Errorf("bad range %s", sym)
+	}
+	for r := first; r <= last; r++ {
+		c.Runes[int(b)+int(r-first)] = r
+	}
+	return nil
+}
+
+// posixSymbol returns the code point a symbolic name such as <U20AC> stands
+// for.
+func posixSymbol(sym string) (rune, error) {
+	if !strings.HasPrefix(sym, "<U") || !strings.HasSuffix(sym, ">") {
+		return 0, fmt.Errorf("unsupported symbolic name %s. expecting "+
+			"<UXXXX>", sym)
+	}
+	r, err := strconv.ParseUint(sym[2:len(sym)-1], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(r)) {
+		return 0, fmt.Errorf("bad symbolic name %s", sym)
+	}
+	return rune(r), nil
+}
+
+// posixByte decodes the encoding of a character, one of /xNN, /dNNN or
+// /oNNN with / being the escape character.
+func posixByte(enc, escape string) (byte, error) {
+	if strings.Count(enc, escape) != 1 || !strings.HasPrefix(enc, escape) ||
+		len(enc) < len(escape)+2 {
+		return 0, fmt.Errorf("%s is not a single byte", enc)
+	}
+	digits := enc[len(escape)+1:]
+	base := map[byte]int{'x': 16, 'd': 10, 'o': 8}[enc[len(escape)]]
+	b, err := strconv.ParseUint(digits, base, 8)
+	if base == 0 || err != nil {
+		return 0, fmt.Errorf("bad byte %s", enc)
+	}
+	return byte(b), nil
+}
+
+// Decoder returns a Stage decoding its input from c into UTF-8.
+func (c *Charmap) Decoder() Stage {
+	d := &charmapDecoder{c: c}
+	for i, r := range c.Runes {
+		d.table[i] = string(r)
+	}
+	return d
+}
+
+// charmapDecoder decodes bytes through the UTF-8 encoding of the character
+// of every byte, compiled ahead of time.
+type charmapDecoder struct {
+	c     *Charmap
+	table [256]string
+}
+
+// Process implements Stage.
+func (d *charmapDecoder) Process(b []byte) []byte {
+	buffer := make([]byte, 0, len(b))
+	for _, c := range b {
+		buffer = append(buffer, d.table[c]...)
+	}
+	return buffer
+}
+
+// Flush implements Stage.
+func (d *charmapDecoder) Flush() []byte {
+	return nil
+}
+
+// singleByte is implemented by the encodings mapping every character to a
+// single byte.
+type singleByte interface {
+	EncodeRune(r rune) (byte, bool)
+}
+
+// ByteMap compiles the conversion from c into the single byte encoding e
+// into a ByteMap, so it can be done byte for byte. It fails when e isn't a
+// single byte encoding, or a byte of c can't be converted under policy.
+func (c *Charmap) ByteMap(e *Encoder) (*ByteMap, error) {
+	sb, ok := e.enc.(singleByte)
+	if !ok {
+		return nil, fmt.Errorf("err: %s isn't a single byte encoding", e.Name)
+	}
+	m := &ByteMap{}
+	for i, r := range c.Runes {
+		b, ok := sb.EncodeRune(r)
+		switch {
+		case ok && r != utf8.RuneError:
+		case e.Policy == UnencodableReplace:
+			b = e.replacement[0]
+		default:
+			return nil, fmt.Errorf("err: byte 0x%02X of %s can't be "+
+				"converted to %s", i, c.Name, e.Name)
+		}
+		m.to[i], m.set[i] = b, true
+	}
+	return m, nil
+}
+
+// charmap returns the charmap selected by the flags, or nil.
+func (f *Flags) charmap() (*Charmap, error) {
+	if f == nil || f.Charmap == "" {
+		return nil, nil
+	}
+	return LoadCharmap(f.Charmap)
+}
+
+// fuseCharmap compiles the stages decoding the input from a charmap and
+// encoding the output into a single byte encoding into a ByteMap, when
+// nothing else comes in between. ok is false when they can't be fused.
+func fuseCharmap(pre, post Pipeline) (m *ByteMap, ok bool) {
+	if len(pre) != 1 || len(post) != 1 {
+		return nil, false
+	}
+	d, isCharmap := pre[0].(*charmapDecoder)
+	e, isEncoder := post[0].(*Encoder)
+	if !isCharmap || !isEncoder {
+		return nil, false
+	}
+	m, err := d.c.ByteMap(e)
+	return m, err == nil
+}
@@ -0,0 +1,132 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const posixCharmap = `<code_set_name> SITE-8
+<comment_char> %
+<escape_char> /
+% the first half is ASCII
+CHARMAP
+<U0000>..<U007F> /x00 BASIC LATIN
+<U00E9>     /xe9  LATIN SMALL LETTER E WITH ACUTE
+<U20AC>     /d128 EURO SIGN
+<U00C9>     /o311 LATIN CAPITAL LETTER E WITH ACUTE
+END CHARMAP
+WIDTH
+<U0000>...<U007F> 1
+END WIDTH
+`
+
+func TestParseCharmap(t *testing.T) {
+	test := []struct {
+		Charmap string
+		Name    string
+		Runes   map[byte]rune
+	}{
+		{posixCharmap, "SITE-8", map[byte]rune{'a': 'a', 0xe9: 'é', 0x80: '€',
+			0xc9: 'É', 0x81: utf8.RuneError}},
+		{"# from Unicode\n0x41\t0x0042\t#B\n0x80\tU+20AC\n0x81\t\t#UNDEFINED\n",
+			"", map[byte]rune{0x41: 'B', 0x80: '€', 0x81: utf8.RuneError}},
+	}
+	for i := 0; i < len(test); i++ {
+		c, err := ParseCharmap(strings.NewReader(test[i].Charmap))
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if c.Name != test[i].Name {
+			t.Errorf("%d: expected name %q. got %q\n", i, test[i].Name, c.Name)
+		}
+		for b, r := range test[i].Runes {
+			if c.Runes[b] != r {
+				t.Errorf("%d: expected 0x%02X to be %q. got %q\n", i, b, r,
+					c.Runes[b])
+			}
+		}
+	}
+	for _, charmap := range []string{
+		"0x41 B\n",
+		"41 U+0042\n",
+		"0x100 U+0042\n",
+		"CHARMAP\n<U00E9> /xc3/xa9\nEND CHARMAP\n",
+		"CHARMAP\n<eacute> /xe9\nEND CHARMAP\n",
+		"CHARMAP\n<U00E9> /xe9\n",
+	} {
+		if _, err := ParseCharmap(strings.NewReader(charmap)); err == nil {
+			t.Errorf("%q: expected error\n", charmap)
+		}
+	}
+}
+
+func TestCharmapConversion(t *testing.T) {
+	c, err := ParseCharmap(strings.NewReader(posixCharmap))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := runStage(c.Decoder(), []byte("caf\xe9 \x80"), 2); string(got) !=
+		"café €" {
+		t.Errorf("expected %q. got %q\n", "café €", got)
+	}
+
+	// the undefined bytes can't be converted, short of a replacement
+	e, _ := NewEncoder("windows-1252", UnencodableError)
+	if _, err = c.ByteMap(e); err == nil {
+		t.Errorf("expected error converting undefined bytes")
+	}
+	e, _ = NewEncoder("windows-1252", UnencodableReplace)
+	m, err := c.ByteMap(e)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := m.Process([]byte("caf\xe9 \x80\x81")); string(got) !=
+		"caf\xe9 \x80?" {
+		t.Errorf("expected %q. got %q\n", "caf\xe9 \x80?", got)
+	}
+	e, _ = NewEncoder("utf-16le", UnencodableReplace)
+	if _, err = c.ByteMap(e); err == nil {
+		t.Errorf("expected error converting to UTF-16")
+	}
+}
+
+func TestStreamCharmap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.map")
+	if err := os.WriteFile(path, []byte(posixCharmap), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	test := []struct {
+		R        R
+		Expected string
+	}{
+		{R{From: []byte("é"), To: []byte("e"), Flag: &Flags{Charmap: path}},
+			"cafe €\n"},
+		// with no operation, the conversion is done byte for byte
+		{R{Flag: &Flags{Charmap: path, OutputEncoding: "latin1",
+			Unencodable: UnencodableReplace}}, "caf\xe9 ?\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		out := &bytes.Buffer{}
+		err := test[i].R.Stream(context.Background(),
+			bytes.NewBufferString("caf\xe9 \x80\n"), out)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if out.String() != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, out)
+		}
+	}
+
+	r := R{Flag: &Flags{Charmap: path, InputEncoding: "latin1"}}
+	if err := r.Stream(context.Background(), &bytes.Buffer{},
+		&bytes.Buffer{}); err == nil {
+		t.Errorf("expected error for --charmap with --input-encoding")
+	}
+}
@@ -0,0 +1,133 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Offender locates a character of the input that the operation configured
+// on r would translate, delete, squeeze or replace.
+type Offender struct {
+	// Line and Col are the 1-based position of the character, Col in bytes
+	Line, Col int
+	// Match is the offending character, or string for a string substitution
+	Match string
+}
+
+// marks records where the operation matches, for Check to locate the
+// offenders.
+type marks struct {
+	// at locates the part of the record the operation is applied to
+	at    position
+	found []mark
+}
+
+// mark is a match of the operation, off bytes into its record.
+type mark struct {
+	off   int
+	match string
+}
+
+// add records match, found off bytes into the part the operation is applied
+// to. A nil marks records nothing.
+func (m *marks) add(off int, match []byte) {
+	if m != nil {
+		m.found = append(m.found, mark{m.at(off), string(match)})
+	}
+}
+
+// checkable reports an error if stages or --regex are configured, as they
+// change the input ahead of the operation, or its output, where Check and
+// Count can't see.
+func (r *R) checkable() error {
+	if r.Flag != nil && (r.Flag.Regex != "" || r.Flag.stageFlagsSet()) {
+		return fmt.Errorf("err: --check and --count don't apply with " +
+			"--regex or stages, eg: --eol, --sanitize, --stage")
+	}
+	return nil
+}
+
+// Count returns how many characters of SET1 occur in the lines, scopes and
+// fields of in selected, going by the compiled set membership tables: a
+// ByteSet for the byte engine, the RuneSet of the rune engine otherwise.
+func (r *R) Count(in io.Reader) (int64, error) {
+	if err := r.checkable(); err != nil {
+		return 0, err
+	}
+	if err := r.compile(); err != nil {
+		return 0, err
+	}
+	var count int64
+	var op func(b []byte, _ position) []byte
+	if r.runes {
+		op = func(b []byte, _ position) []byte {
+			for i := 0; i < len(b); {
+				c, size := utf8.DecodeRune(b[i:])
+				i += size
+				// invalid UTF-8 is never a member
+				if (c != utf8.RuneError || size > 1) && r.runeOp.selects(c) {
+					count++
+				}
+			}
+			return b
+		}
+	} else {
+		set, err := r.Set()
+		if err != nil {
+			return 0, err
+		}
+		op = func(b []byte, _ position) []byte {
+			for _, c := range b {
+				if set.Has(c) {
+					count++
+				}
+			}
+			return b
+		}
+	}
+	err := r.records(in, op, func(int, []byte, []byte) error {
+		return nil
+	})
+	return count, err
+}
+
+// Check scans in for the characters the operation configured on r would
+// translate, delete, squeeze or replace, running every line through it just
+// like Stream does, on the lines, scopes and fields selected and within its
+// limits. The engines report every match they act on, so they're located in
+// the input as read. It returns the first limit of them (all of them if
+// limit is negative), along with how many there are in total.
+func (r *R) Check(in io.Reader, limit int) ([]Offender, int64, error) {
+	if err := r.checkable(); err != nil {
+		return nil, 0, err
+	}
+	m := &marks{}
+	r.marks = m
+	defer func() {
+		r.marks = nil
+	}()
+	op := func(b []byte, at position) []byte {
+		m.at = at
+		return r.Apply(context.Background(), b)
+	}
+	var offenders []Offender
+	var total int64
+	err := r.records(in, op, func(first int, raw, _ []byte) error {
+		for _, k := range m.found {
+			total++
+			if limit >= 0 && len(offenders) >= limit {
+				continue
+			}
+			// a CSV record or JSON document may span several lines
+			line := first + bytes.Count(raw[:k.off], []byte("\n"))
+			col := k.off - bytes.LastIndexByte(raw[:k.off], '\n')
+			offenders = append(offenders, Offender{line, col, k.match})
+		}
+		m.found = m.found[:0]
+		return nil
+	})
+	return offenders, total, err
+}
@@ -0,0 +1,153 @@
+package r
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	test := []struct {
+		R       R
+		Members string
+	}{
+		{R{From: []byte("a-c"), To: []byte("x-z")}, "abc"},
+		{R{From: []byte("[:alnum:]"), To: []byte("x")},
+			"0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: "a-c"}}, "-ac"},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_SQUEEZE,
+			SqueezeBytes: []byte("xy")}}, "xy"},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: `\x00-\x02\n`}}, "\x00\x01\x02\n"},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: `\200-\202`}}, "\x80\x81\x82"},
+	}
+	for i := 0; i < len(test); i++ {
+		set, err := test[i].R.Set()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var got []byte
+		for c := 0; c < 256; c++ {
+			if set.Has(byte(c)) {
+				got = append(got, byte(c))
+			}
+		}
+		if string(got) != test[i].Members {
+			t.Errorf("expected %s. got %s\n", test[i].Members, got)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	test := []struct {
+		R         R
+		RawString string
+		Count     int64
+	}{
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: "[:upper:]"}}, "Hello World\nABC", 5},
+		// members of SET1 count whether or not they'd change
+		{R{From: []byte("a-z"), To: []byte("a-z")}, "ab1\n", 2},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: "a"}}, strings.Repeat("ab", 1500) + "\n", 1500},
+		// characters, not bytes, through the rune engine
+		{R{From: []byte("é"), To: []byte("e")}, "café é\n", 2},
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Complement: true}},
+			"aéb\n", 3},
+		// on the lines and fields selected
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Lines: "2",
+			Fields: "1"}}, "a\ta\na\ta\n", 1},
+	}
+	for i := 0; i < len(test); i++ {
+		count, err := test[i].R.Count(bytes.NewBufferString(test[i].RawString))
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if count != test[i].Count {
+			t.Errorf("%d: expected %d. got %d\n", i, test[i].Count, count)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	test := []struct {
+		R         R
+		RawString string
+		Limit     int
+		Offenders []Offender
+		Total     int64
+	}{
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: "\r"}}, "ok\nbad\r\nbad\r\n", 1,
+			[]Offender{{2, 4, "\r"}}, 2},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_SQUEEZE,
+			SqueezeBytes: []byte(" ")}}, "a b\na  b\n", -1,
+			[]Offender{{2, 3, " "}}, 1},
+		{R{From: []byte("x"), To: []byte("y")}, "abc\n", -1, nil, 0},
+		{R{From: []byte("fo"), To: []byte("xy")}, "of\nfox\n", -1,
+			[]Offender{{2, 1, "fo"}}, 1},
+		{R{From: []byte("a-c"), To: []byte("a-c")}, "abc\n", -1,
+			[]Offender{{1, 1, "a"}, {1, 2, "b"}, {1, 3, "c"}}, 3},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_SQUEEZE,
+			SqueezeBytes: []byte("\n")}}, "a\n\n\nb\n", -1,
+			[]Offender{{2, 1, "\n"}, {3, 1, "\n"}}, 2},
+		// a single byte replaced by several
+		{R{From: []byte("a"), To: []byte("xyz")}, "aba\n", -1,
+			[]Offender{{1, 1, "a"}, {1, 3, "a"}}, 2},
+		// lines too long to be diffed
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: "a"}}, strings.Repeat("ab", 1500) + "\n", 2,
+			[]Offender{{1, 1, "a"}, {1, 3, "a"}}, 1500},
+		// within the lines, scopes, fields and limits selected
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Lines: "2"}},
+			"a\na\na\n", -1, []Offender{{2, 1, "a"}}, 1},
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Within: `\[.*\]`}},
+			"a [a] a\n", -1, []Offender{{1, 4, "a"}}, 1},
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Fields: "2",
+			Within: "a$"}}, "a\txa\ta\n", -1, []Offender{{1, 4, "a"}}, 1},
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Fields: "2",
+			CSV: true}}, "a,\"a\na\",a\n", -1,
+			[]Offender{{1, 4, "a"}, {2, 1, "a"}}, 2},
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Fields: "1",
+			CSV: true}}, "\"\"\"a\",a\n", -1, []Offender{{1, 4, "a"}}, 1},
+		// a JSON string is located at its opening quote
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{JSONL: true}},
+			"{\"a\": \"xa\"}\n", -1, []Offender{{1, 7, "a"}}, 1},
+		{R{From: []byte("a"), To: []byte("b"), Flag: &Flags{Max: 2}},
+			"aaa\n", -1, []Offender{{1, 1, "a"}, {1, 2, "a"}}, 2},
+		{R{From: []byte("é"), To: []byte("e")}, "café\n", -1,
+			[]Offender{{1, 4, "é"}}, 1},
+	}
+	for i := 0; i < len(test); i++ {
+		got, total, err := test[i].R.Check(
+			bytes.NewBufferString(test[i].RawString), test[i].Limit)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if total != test[i].Total || len(got) != len(test[i].Offenders) {
+			t.Errorf("%d: expected %v (%d). got %v (%d)\n", i,
+				test[i].Offenders, test[i].Total, got, total)
+			continue
+		}
+		for j := range got {
+			if got[j] != test[i].Offenders[j] {
+				t.Errorf("%d: expected %v. got %v\n", i, test[i].Offenders, got)
+			}
+		}
+	}
+}
+
+func TestCheckStages(t *testing.T) {
+	for _, f := range []Flags{{EOL: "lf"}, {Sanitize: "ansi"}, {Regex: "a"},
+		{Stages: []string{"rot13"}}} {
+		r := R{From: []byte("\r"), To: []byte("x"), Flag: &f}
+		if _, _, err := r.Check(bytes.NewBufferString("a\r\n"), -1); err == nil {
+			t.Errorf("%+v: expected --check to fail\n", f)
+		}
+		if _, err := r.Count(bytes.NewBufferString("a\r\n")); err == nil {
+			t.Errorf("%+v: expected --count to fail\n", f)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package r
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	UnencodableError   = "error"
+	UnencodableReplace = "replace"
+	UnencodableSkip    = "skip"
+)
+
+// encodingAliases are names in common use missing from the IANA registry.
+var encodingAliases = map[string]encoding.Encoding{
+	"ebcdic":   charmap.CodePage037,
+	"utf-16le": unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be": unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+}
+
+// LookupEncoding resolves the name of an encoding, eg: ISO-8859-1, latin1,
+// windows-1252, cp1252, IBM037, ebcdic or UTF-16LE.
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	key := strings.ToLower(name)
+	if e, ok := encodingAliases[key]; ok {
+		return e, nil
+	}
+	if strings.HasPrefix(key, "cp125") {
+		key = "windows-" + key[2:]
+	}
+	e, err := ianaindex.IANA.Encoding(key)
+	if err != nil || e == nil {
+		return nil, fmt.Errorf("err: unknown or unsupported encoding %q", name)
+	}
+	return e, nil
+}
+
+// isUTF16 reports whether name is one of the UTF-16 encodings.
+func isUTF16(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "utf-16")
+}
+
+// NewDecoder returns a Stage decoding its input from the encoding name into
+// UTF-8. A UTF-16 input starting with a byte order mark is decoded in the
+// byte order the mark gives, whatever name says.
+func NewDecoder(name string) (Stage, error) {
+	e, err := LookupEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	var t transform.Transformer = e.NewDecoder()
+	if isUTF16(name) {
+		t = unicode.BOMOverride(t)
+	}
+	return &transformStage{t: t}, nil
+}
+
+// Encoder is a Stage encoding its UTF-8 input into another encoding.
+// Characters the encoding has no room for are dealt with according to
+// Policy: they stop the encoding with an error, are replaced by a question
+// mark, or are skipped.
+type Encoder struct {
+	// Name is the encoding, eg: ISO-8859-1
+	Name string
+	// Policy is one of UnencodableError, UnencodableReplace or
+	// UnencodableSkip
+	Policy      string
+	enc         encoding.Encoding
+	t           transform.Transformer
+	replacement []byte
+	held        []byte
+	err         error
+}
+
+// NewEncoder returns an Encoder into the encoding name.
+func NewEncoder(name, policy string) (*Encoder, error) {
+	switch policy {
+	case UnencodableError, UnencodableReplace, UnencodableSkip:
+	default:
+		return nil, fmt.Errorf("err: unknown unencodable policy %q. "+
+			"expecting %s, %s or %s", policy, UnencodableError,
+			UnencodableReplace, UnencodableSkip)
+	}
+	e, err := LookupEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := e.NewEncoder().Bytes([]byte("?"))
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{Name: name, Policy: policy, enc: e, t: e.NewEncoder(),
+		replacement: replacement}, nil
+}
+
+// Process implements Stage.
+func (e *Encoder) Process(b []byte) []byte {
+	return e.run(b, false)
+}
+
+// Flush implements Stage.
+func (e *Encoder) Flush() []byte {
+	defer e.t.Reset()
+	return e.run(nil, true)
+}
+
+// Err returns the error that stopped e, if any.
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+func (e *Encoder) run(b []byte, atEOF bool) []byte {
+	if e.err != nil {
+		return nil
+	}
+	src := append(e.held, b...)
+	e.held = nil
+	dst := make([]byte, 2*len(src)+16)
+	var buffer []byte
+	for {
+		nDst, nSrc, err := e.t.Transform(dst, src, atEOF)
+		buffer = append(buffer, dst[:nDst]...)
+		src = src[nSrc:]
+		switch err {
+		case nil:
+			return buffer
+		case transform.ErrShortDst:
+			if nDst == 0 {
+				dst = make([]byte, 2*len(dst))
+			}
+			continue
+		case transform.ErrShortSrc:
+			if !atEOF {
+				e.held = append(e.held, src...)
+				return buffer
+			}
+		}
+		// the character at the start of src can't be encoded
+		c, size := utf8.DecodeRune(src)
+		switch e.Policy {
+		case UnencodableError:
+			if c == utf8.RuneError && size <= 1 {
+				e.err = fmt.Errorf("err: invalid UTF-8 can't be encoded in %s",
+					e.Name)
+			} else {
+				e.err = fmt.Errorf("err: %U %q can't be encoded in %s", c, c,
+					e.Name)
+			}
+			return buffer
+		case UnencodableReplace:
+			buffer = append(buffer, e.replacement...)
+		}
+		if size == 0 {
+			return buffer
+		}
+		src = src[size:]
+	}
+}
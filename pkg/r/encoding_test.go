@@ -0,0 +1,109 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestLookupEncoding(t *testing.T) {
+	for _, name := range []string{"latin1", "ISO-8859-15", "windows-1252",
+		"cp1252", "IBM037", "ebcdic", "UTF-16", "utf-16le", "UTF-16BE"} {
+		if _, err := LookupEncoding(name); err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+	}
+	for _, name := range []string{"klingon", "ibm500"} {
+		if _, err := LookupEncoding(name); err == nil {
+			t.Errorf("%s: expected error\n", name)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	test := []struct {
+		Encoding string
+		Raw      string
+		Expected string
+	}{
+		{"latin1", "caf\xe9", "café"},
+		{"windows-1252", "\x93hi\x94 \x80", "“hi” €"},
+		{"IBM037", "\xc8\x85\x93\x93\x96\x25", "Hello\n"},
+		{"utf-16le", "h\x00\xe9\x00", "hé"},
+		// the byte order mark overrides the byte order of the name
+		{"utf-16le", "\xfe\xff\x00h\x00i", "hi"},
+		{"utf-16", "\xff\xfeh\x00i\x00", "hi"},
+		{"utf-16", "\x00h\x00i", "hi"},
+	}
+	for i := 0; i < len(test); i++ {
+		d, err := NewDecoder(test[i].Encoding)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if got := runStage(d, []byte(test[i].Raw), 1); string(got) !=
+			test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, got)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	test := []struct {
+		Encoding string
+		Policy   string
+		Raw      string
+		Expected string
+		Err      bool
+	}{
+		{"latin1", UnencodableError, "café", "caf\xe9", false},
+		{"latin1", UnencodableError, "a€b", "a", true},
+		{"latin1", UnencodableReplace, "a€b", "a?b", false},
+		{"latin1", UnencodableSkip, "a€b", "ab", false},
+		{"windows-1252", UnencodableError, "€", "\x80", false},
+		{"IBM037", UnencodableReplace, "Hi?\n", "\xc8\x89\x6f\x25", false},
+		{"utf-16be", UnencodableError, "hé", "\x00h\x00\xe9", false},
+	}
+	for i := 0; i < len(test); i++ {
+		e, err := NewEncoder(test[i].Encoding, test[i].Policy)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		// a size of 1 splits multi-byte characters across chunks
+		got := runStage(e, []byte(test[i].Raw), 1)
+		if string(got) != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, got)
+		}
+		if (e.Err() != nil) != test[i].Err {
+			t.Errorf("%d: expected error to be %v. got %v\n", i, test[i].Err,
+				e.Err())
+		}
+	}
+	if _, err := NewEncoder("latin1", "ignore"); err == nil {
+		t.Errorf("expected error for unknown policy")
+	}
+}
+
+func TestStreamEncoding(t *testing.T) {
+	r := R{From: []byte("é"), To: []byte("e"),
+		Flag: &Flags{InputEncoding: "latin1", OutputEncoding: "IBM037",
+			Unencodable: UnencodableError}}
+	out := &bytes.Buffer{}
+	err := r.Stream(context.Background(), bytes.NewBufferString("caf\xe9\n"),
+		out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "\x83\x81\x86\x85\x25"; out.String() != expected {
+		t.Errorf("expected %q. got %q\n", expected, out.String())
+	}
+
+	r = R{From: []byte("a"), To: []byte("€"),
+		Flag: &Flags{OutputEncoding: "latin1", Unencodable: UnencodableError}}
+	err = r.Stream(context.Background(), bytes.NewBufferString("abc\n"),
+		&bytes.Buffer{})
+	if err == nil {
+		t.Errorf("expected error for a character latin1 can't encode")
+	}
+}
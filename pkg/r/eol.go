@@ -0,0 +1,144 @@
+package r
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+const (
+	EOL_LF   = "lf"
+	EOL_CRLF = "crlf"
+	EOL_CR   = "cr"
+	EOL_AUTO = "auto"
+)
+
+// EOL is a Stage normalising line endings: every CRLF pair, lone CR and lone
+// LF in its input is replaced by To.
+type EOL struct {
+	// To is the line ending written out
+	To []byte
+	// held is set when the last chunk ended on a CR, which may be the first
+	// half of a CRLF pair split across chunks
+	held bool
+}
+
+// ParseEOL returns the EOL stage converting line endings to mode, one of lf,
+// crlf, cr or auto. auto picks the native line ending of the platform.
+func ParseEOL(mode string) (*EOL, error) {
+	if mode == EOL_AUTO {
+		mode = EOL_LF
+		if runtime.GOOS == "windows" {
+			mode = EOL_CRLF
+		}
+	}
+	switch mode {
+	case EOL_LF:
+		return &EOL{To: []byte("\n")}, nil
+	case EOL_CRLF:
+		return &EOL{To: []byte("\r\n")}, nil
+	case EOL_CR:
+		return &EOL{To: []byte("\r")}, nil
+	}
+	return nil, fmt.Errorf("err: unknown line ending %q. expecting %s, %s, "+
+		"%s or %s", mode, EOL_LF, EOL_CRLF, EOL_CR, EOL_AUTO)
+}
+
+// Process implements Stage.
+func (e *EOL) Process(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	buffer := make([]byte, 0, len(b)+1)
+	i := 0
+	if e.held {
+		e.held = false
+		buffer = append(buffer, e.To...)
+		if b[0] == '\n' {
+			i++
+		}
+	}
+	for ; i < len(b); i++ {
+		switch b[i] {
+		case '\r':
+			if i+1 == len(b) {
+				e.held = true
+				continue
+			}
+			if b[i+1] == '\n' {
+				i++
+			}
+			buffer = append(buffer, e.To...)
+		case '\n':
+			buffer = append(buffer, e.To...)
+		default:
+			buffer = append(buffer, b[i])
+		}
+	}
+	return buffer
+}
+
+// Flush implements Stage.
+func (e *EOL) Flush() []byte {
+	if !e.held {
+		return nil
+	}
+	e.held = false
+	return e.To
+}
+
+// EOLCounts tallies the line endings found in some input.
+type EOLCounts struct {
+	LF, CRLF, CR int64
+}
+
+// String describes c, eg: lf=10 crlf=2 cr=0 (mixed)
+func (c EOLCounts) String() string {
+	kinds := 0
+	for _, n := range []int64{c.LF, c.CRLF, c.CR} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	desc := "none"
+	switch {
+	case kinds > 1:
+		desc = "mixed"
+	case c.LF > 0:
+		desc = EOL_LF
+	case c.CRLF > 0:
+		desc = EOL_CRLF
+	case c.CR > 0:
+		desc = EOL_CR
+	}
+	return fmt.Sprintf("lf=%d crlf=%d cr=%d (%s)", c.LF, c.CRLF, c.CR, desc)
+}
+
+// DetectEOL counts the line endings of each kind read from in.
+func DetectEOL(in io.Reader) (EOLCounts, error) {
+	var c EOLCounts
+	br := bufio.NewReader(in)
+	cr := false
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			if cr {
+				c.CR++
+			}
+			return c, nil
+		}
+		if err != nil {
+			return c, err
+		}
+		switch {
+		case cr && b == '\n':
+			c.CRLF++
+		case cr:
+			c.CR++
+		case b == '\n':
+			c.LF++
+		}
+		cr = b == '\r'
+	}
+}
@@ -0,0 +1,54 @@
+package r
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEOL(t *testing.T) {
+	test := []struct {
+		Mode       string
+		RawString  string
+		DestString string
+	}{
+		{"lf", "a\r\nb\rc\nd", "a\nb\nc\nd"},
+		{"crlf", "a\r\nb\rc\n", "a\r\nb\r\nc\r\n"},
+		{"cr", "a\r\nb\n\n", "a\rb\r\r"},
+		{"lf", "\r\r\n\r", "\n\n\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		// whole, and a byte at a time so CRLF pairs get split
+		for _, size := range []int{len(test[i].RawString), 1} {
+			e, err := ParseEOL(test[i].Mode)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			raw := []byte(test[i].RawString)
+			var got []byte
+			for j := 0; j < len(raw); j += size {
+				got = append(got, e.Process(raw[j:j+size])...)
+			}
+			got = append(got, e.Flush()...)
+			if string(got) != test[i].DestString {
+				t.Errorf("%s/%d: expected %q. got %q\n", test[i].Mode, size,
+					test[i].DestString, got)
+			}
+		}
+	}
+	if _, err := ParseEOL("unix"); err == nil {
+		t.Errorf("expected error for unknown mode")
+	}
+}
+
+func TestDetectEOL(t *testing.T) {
+	c, err := DetectEOL(bytes.NewBufferString("a\r\nb\r\nc\rd\ne\r"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c != (EOLCounts{LF: 1, CRLF: 2, CR: 2}) {
+		t.Errorf("unexpected counts %s\n", c)
+	}
+	if c.String() != "lf=1 crlf=2 cr=2 (mixed)" {
+		t.Errorf("unexpected description %s\n", c)
+	}
+}
@@ -0,0 +1,358 @@
+package r
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	EscapeC     = "c"
+	EscapeJSON  = "json"
+	EscapeURL   = "url"
+	EscapeHTML  = "html"
+	EscapeShell = "shell"
+)
+
+// lineStage is a Stage running fn over its input line by line. Newlines
+// are left out of what fn is handed, and kept as they are, unless eol is
+// set, in which case fn is handed them along with the line.
+type lineStage struct {
+	fn   func(dst, line []byte) []byte
+	eol  bool
+	held []byte
+}
+
+// Process implements Stage.
+func (s *lineStage) Process(b []byte) []byte {
+	b = append(s.held, b...)
+	end := bytes.LastIndexByte(b, '\n')
+	s.held = append([]byte(nil), b[end+1:]...)
+	var buffer []byte
+	for _, line := range bytes.SplitAfter(b[:end+1], []byte("\n")) {
+		switch {
+		case len(line) == 0:
+		case s.eol:
+			buffer = s.fn(buffer, line)
+		default:
+			buffer = append(s.fn(buffer, line[:len(line)-1]), '\n')
+		}
+	}
+	return buffer
+}
+
+// Flush implements Stage.
+func (s *lineStage) Flush() []byte {
+	if len(s.held) == 0 {
+		return nil
+	}
+	defer func() { s.held = nil }()
+	return s.fn(nil, s.held)
+}
+
+// NewEscaper returns a Stage converting control and special characters of
+// its input into the escape sequences of scheme, one of c, json, url, html
+// or shell. Newlines are kept as they are, so the line structure of the
+// input is too. With shell, every line is quoted as a single word. JSON
+// strings can't hold invalid UTF-8, which json replaces with \uFFFD,
+// counting the bytes replaced into stats, which may be nil.
+func NewEscaper(scheme string, stats *Stats) (Stage, error) {
+	fn, ok := map[string]func(dst, line []byte) []byte{
+		EscapeC: escapeC,
+		EscapeJSON: func(dst, line []byte) []byte {
+			return escapeJSON(dst, line, stats)
+		},
+		EscapeURL:   escapeURL,
+		EscapeHTML:  escapeHTML,
+		EscapeShell: escapeShell,
+	}[scheme]
+	if !ok {
+		return nil, unknownScheme(scheme)
+	}
+	return &lineStage{fn: fn}, nil
+}
+
+// NewUnescaper returns a Stage converting the escape sequences of scheme
+// back into the characters they stand for. Malformed sequences are kept as
+// they are.
+func NewUnescaper(scheme string) (Stage, error) {
+	fn, ok := map[string]func(dst, line []byte) []byte{
+		EscapeC:     unescapeC,
+		EscapeJSON:  unescapeJSON,
+		EscapeURL:   unescapeURL,
+		EscapeHTML:  unescapeHTML,
+		EscapeShell: unescapeShell,
+	}[scheme]
+	if !ok {
+		return nil, unknownScheme(scheme)
+	}
+	return &lineStage{fn: fn}, nil
+}
+
+func unknownScheme(scheme string) error {
+	return fmt.Errorf("err: unknown escape scheme %q. expecting %s, %s, %s, "+
+		"%s or %s", scheme, EscapeC, EscapeJSON, EscapeURL, EscapeHTML,
+		EscapeShell)
+}
+
+// cEscapes are the single character escapes shared by C and JSON.
+var cEscapes = map[byte]byte{
+	'\b': 'b', '\f': 'f', '\r': 'r', '\t': 't', '"': '"', '\\': '\\',
+}
+
+// escapeC escapes the control characters, quotes and backslashes of line,
+// along with every byte outside of ASCII, as C does.
+func escapeC(dst, line []byte) []byte {
+	for _, c := range line {
+		switch e, ok := cEscapes[c]; {
+		case ok:
+			dst = append(dst, '\\', e)
+		case c == '\a':
+			dst = append(dst, `\a`...)
+		case c == '\v':
+			dst = append(dst, `\v`...)
+		case c < 0x20 || c >= 0x7f:
+			// octal, since \x takes in as many hex digits as follow it
+			dst = append(dst, fmt.Sprintf(`\%03o`, c)...)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// escapeJSON escapes line as in a JSON string. Bytes that aren't valid
+// UTF-8 are replaced with \uFFFD and counted into stats.
+func escapeJSON(dst, line []byte, stats *Stats) []byte {
+	for i := 0; i < len(line); {
+		c, size := utf8.DecodeRune(line[i:])
+		e, ok := cEscapes[line[i]]
+		switch {
+		case ok:
+			dst = append(dst, '\\', e)
+		case c == utf8.RuneError && size == 1:
+			stats.invalid(1)
+			dst = append(dst, `\ufffd`...)
+		case c < 0x20 || c == 0x7f:
+			dst = append(dst, fmt.Sprintf(`\u%04x`, line[i])...)
+		default:
+			dst = append(dst, line[i:i+size]...)
+		}
+		i += size
+	}
+	return dst
+}
+
+// escapeURL percent-encodes every byte of line but the unreserved
+// characters of RFC 3986.
+func escapeURL(dst, line []byte) []byte {
+	for _, c := range line {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			dst = append(dst, c)
+		default:
+			dst = append(dst, fmt.Sprintf("%%%02X", c)...)
+		}
+	}
+	return dst
+}
+
+func escapeHTML(dst, line []byte) []byte {
+	return append(dst, html.EscapeString(string(line))...)
+}
+
+// escapeShell quotes line as a single word of the POSIX shell.
+func escapeShell(dst, line []byte) []byte {
+	dst = append(dst, '\'')
+	for _, c := range line {
+		if c == '\'' {
+			dst = append(dst, `'\''`...)
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '\'')
+}
+
+// unescapeC unescapes the escape sequences of C in line: the single
+// character escapes, \NNN in octal, \xHH, \uXXXX and \UXXXXXXXX.
+func unescapeC(dst, line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\\' || i+1 == len(line) {
+			dst = append(dst, line[i])
+			continue
+		}
+		c, n, ok := cUnescape(line[i+1:])
+		if !ok {
+			dst = append(dst, line[i])
+			continue
+		}
+		dst = append(dst, c...)
+		i += n
+	}
+	return dst
+}
+
+// cUnescape decodes the C escape sequence following a backslash at the start
+// of s, returning what it stands for and how many bytes it takes up.
+func cUnescape(s []byte) ([]byte, int, bool) {
+	simple := map[byte]byte{'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n',
+		'r': '\r', 't': '\t', 'v': '\v', '\\': '\\', '\'': '\'', '"': '"',
+		'?': '?'}
+	if c, ok := simple[s[0]]; ok {
+		return []byte{c}, 1, true
+	}
+	switch {
+	case '0' <= s[0] && s[0] <= '7':
+		n := digits(s, 3, 8)
+		v, _ := strconv.ParseUint(string(s[:n]), 8, 16)
+		if v > 0xff {
+			n--
+			v >>= 3
+		}
+		return []byte{byte(v)}, n, true
+	case s[0] == 'x':
+		n := digits(s[1:], 2, 16)
+		if n == 0 {
+			return nil, 0, false
+		}
+		v, _ := strconv.ParseUint(string(s[1:1+n]), 16, 8)
+		return []byte{byte(v)}, n + 1, true
+	case s[0] == 'u' || s[0] == 'U':
+		want := 4
+		if s[0] == 'U' {
+			want = 8
+		}
+		if digits(s[1:], want, 16) != want {
+			return nil, 0, false
+		}
+		v, _ := strconv.ParseUint(string(s[1:1+want]), 16, 32)
+		if !utf8.ValidRune(rune(v)) {
+			return nil, 0, false
+		}
+		return utf8.AppendRune(nil, rune(v)), want + 1, true
+	}
+	return nil, 0, false
+}
+
+// digits counts the digits of base at the start of s, up to max.
+func digits(s []byte, max, base int) int {
+	n := 0
+	for n < len(s) && n < max {
+		if _, err := strconv.ParseUint(string(s[n]), base, 8); err != nil {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// unescapeJSON unescapes the escape sequences of a JSON string in line,
+// joining surrogate pairs.
+func unescapeJSON(dst, line []byte) []byte {
+	simple := map[byte]byte{'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r',
+		't': '\t', '"': '"', '\\': '\\', '/': '/'}
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\\' || i+1 == len(line) {
+			dst = append(dst, line[i])
+			continue
+		}
+		if c, ok := simple[line[i+1]]; ok {
+			dst = append(dst, c)
+			i++
+			continue
+		}
+		r, n := jsonRune(line[i+1:])
+		if n == 0 {
+			dst = append(dst, line[i])
+			continue
+		}
+		dst = utf8.AppendRune(dst, r)
+		i += n
+	}
+	return dst
+}
+
+// jsonRune decodes the \uXXXX escape following a backslash at the start of
+// s, along with the low half of a surrogate pair, returning the character
+// and how many bytes it takes up.
+func jsonRune(s []byte) (rune, int) {
+	if len(s) < 5 || s[0] != 'u' || digits(s[1:], 4, 16) != 4 {
+		return 0, 0
+	}
+	v, _ := strconv.ParseUint(string(s[1:5]), 16, 32)
+	r := rune(v)
+	if r < 0xd800 || r > 0xdbff {
+		return r, 5
+	}
+	if len(s) > 5 && s[5] == '\\' {
+		if lo, n := jsonRune(s[6:]); n == 5 && lo >= 0xdc00 && lo <= 0xdfff {
+			return 0x10000 + (r-0xd800)<<10 + (lo - 0xdc00), 11
+		}
+	}
+	return utf8.RuneError, 5
+}
+
+// unescapeURL decodes the percent-encoded bytes of line.
+func unescapeURL(dst, line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '%' && digits(line[i+1:], 2, 16) == 2 {
+			v, _ := strconv.ParseUint(string(line[i+1:i+3]), 16, 8)
+			dst = append(dst, byte(v))
+			i += 2
+			continue
+		}
+		dst = append(dst, line[i])
+	}
+	return dst
+}
+
+func unescapeHTML(dst, line []byte) []byte {
+	return append(dst, html.UnescapeString(string(line))...)
+}
+
+// unescapeShell removes the quoting of POSIX shell words from line: single
+// quotes, double quotes, $'...' quotes and backslashes. An unterminated
+// quote runs to the end of the line.
+func unescapeShell(dst, line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\'':
+			end := bytes.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			dst = append(dst, line[i+1:i+1+end]...)
+			i += end + 1
+		case c == '$' && i+1 < len(line) && line[i+1] == '\'':
+			// ANSI-C quoting, where backslash escapes work as in C
+			for i += 2; i < len(line) && line[i] != '\''; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					if e, n, ok := cUnescape(line[i+1:]); ok {
+						dst = append(dst, e...)
+						i += n
+						continue
+					}
+				}
+				dst = append(dst, line[i])
+			}
+		case c == '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) &&
+					bytes.IndexByte([]byte("$`\"\\"), line[i+1]) >= 0 {
+					i++
+				}
+				dst = append(dst, line[i])
+			}
+		case c == '\\' && i+1 < len(line):
+			dst = append(dst, line[i+1])
+			i++
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,107 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestEscaper(t *testing.T) {
+	test := []struct {
+		Scheme     string
+		RawString  string
+		DestString string
+	}{
+		{EscapeC, "a\x01\"\\\t\xff\n\a", `a\001\"\\\t\377` + "\n" + `\a`},
+		{EscapeJSON, "é\"\x01\xff\r\n", `é\"\u0001\ufffd\r` + "\n"},
+		{EscapeURL, "a b/é~\n", "a%20b%2F%C3%A9~\n"},
+		{EscapeHTML, `<a href="x">&'`, "&lt;a href=&#34;x&#34;&gt;&amp;&#39;"},
+		{EscapeShell, "it's\n\n", `'it'\''s'` + "\n''\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		e, err := NewEscaper(test[i].Scheme, nil)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if got := runStage(e, []byte(test[i].RawString), 3); string(got) !=
+			test[i].DestString {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].DestString, got)
+		}
+	}
+	if _, err := NewEscaper("base64", nil); err == nil {
+		t.Errorf("expected error for unknown scheme")
+	}
+}
+
+func TestUnescaper(t *testing.T) {
+	test := []struct {
+		Scheme     string
+		RawString  string
+		DestString string
+	}{
+		{EscapeC, `\x41\101é\U0001F600\n\q\`, "AAé😀\n\\q\\"},
+		{EscapeC, `\400\x`, " 0\\x"},
+		{EscapeJSON, `\"é😀\/\ud83d`, "\"é😀/�"},
+		{EscapeURL, "a%20b%2f%zz%4", "a b/%zz%4"},
+		{EscapeHTML, "&lt;&eacute;&#39;&bogus;", "<é'&bogus;"},
+		{EscapeShell, `'it'\''s' "a \"b\"" $'\t\x41' c\ d 'open`,
+			"it's a \"b\" \tA c d open"},
+	}
+	for i := 0; i < len(test); i++ {
+		u, err := NewUnescaper(test[i].Scheme)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if got := runStage(u, []byte(test[i].RawString), 3); string(got) !=
+			test[i].DestString {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].DestString, got)
+		}
+	}
+}
+
+func TestEscapeRoundTrip(t *testing.T) {
+	raw := []byte("plain \"quoted\" it's <b>&\t\x00\x7f é\r\nsecond line\n")
+	for _, scheme := range []string{EscapeC, EscapeURL, EscapeHTML,
+		EscapeShell, EscapeJSON} {
+		e, _ := NewEscaper(scheme, nil)
+		u, _ := NewUnescaper(scheme)
+		if got := runStage(u, runStage(e, raw, 5), 7); !bytes.Equal(got, raw) {
+			t.Errorf("%s: expected %q. got %q\n", scheme, raw, got)
+		}
+	}
+}
+
+func TestEscapeJSONInvalid(t *testing.T) {
+	// JSON can't hold invalid UTF-8, so it doesn't survive a round trip
+	stats := NewStats()
+	e, _ := NewEscaper(EscapeJSON, stats)
+	u, _ := NewUnescaper(EscapeJSON)
+	escaped := runStage(e, []byte("caf\xe9 \xff\xfe\n"), 3)
+	if expected := `caf\ufffd \ufffd\ufffd` + "\n"; string(escaped) != expected {
+		t.Errorf("expected %q. got %q\n", expected, escaped)
+	}
+	if got := runStage(u, escaped, 3); string(got) != "caf\uFFFD \uFFFD\uFFFD\n" {
+		t.Errorf("expected %q. got %q\n", "caf\uFFFD \uFFFD\uFFFD\n", got)
+	}
+	if stats.Invalid != 3 {
+		t.Errorf("expected 3 bytes replaced. got %d\n", stats.Invalid)
+	}
+}
+
+func TestStreamEscape(t *testing.T) {
+	// binary bytes are made printable, translated and converted back
+	r := R{From: []byte("0-7"), To: []byte("a-h"),
+		Flag: &Flags{Escape: EscapeC, Unescape: EscapeC}}
+	out := &bytes.Buffer{}
+	err := r.Stream(context.Background(), bytes.NewBufferString("\x01\x029\n"),
+		out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// \001\0029 translates to \aab\aac9, where \a unescapes to BEL
+	if expected := "\aab\aac9\n"; out.String() != expected {
+		t.Errorf("expected %q. got %q\n", expected, out.String())
+	}
+}
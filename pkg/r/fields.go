@@ -0,0 +1,228 @@
+package r
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Fields selects the columns of delimited input that get processed. Columns
+// not selected, and the delimiters themselves, pass through unchanged.
+type Fields struct {
+	// ranges holds the 1-based, inclusive column ranges selected. An end of -1
+	// means the range runs to the last column. nil selects every column.
+	ranges [][2]int
+	// Delimiter separates the columns of a record
+	Delimiter byte
+	// CSV enables RFC 4180 parsing, so quoted fields may contain delimiters,
+	// quotes and newlines.
+	CSV bool
+}
+
+// ParseFields parses a column list in the form 1,3-5,7- into a Fields
+// delimited by delim.
+func ParseFields(list string, delim byte, csv bool) (*Fields, error) {
+	f := &Fields{Delimiter: delim, CSV: csv}
+	if list == "" {
+		return f, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		first, last, found := strings.Cut(part, "-")
+		var rng [2]int
+		var err error
+		if rng[0], err = strconv.Atoi(first); err != nil || rng[0] < 1 {
+			return nil, fmt.Errorf("err: invalid field %q in list %q", part,
+				list)
+		}
+		switch {
+		case !found:
+			rng[1] = rng[0]
+		case last == "":
+			rng[1] = -1
+		default:
+			if rng[1], err = strconv.Atoi(last); err != nil || rng[1] < rng[0] {
+				return nil, fmt.Errorf("err: invalid field range %q in list"+
+					" %q", part, list)
+			}
+		}
+		f.ranges = append(f.ranges, rng)
+	}
+	return f, nil
+}
+
+// Selects reports whether the n-th column (1-based) is selected.
+func (f *Fields) Selects(n int) bool {
+	if f.ranges == nil {
+		return true
+	}
+	for _, rng := range f.ranges {
+		if n >= rng[0] && (rng[1] < 0 || n <= rng[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply runs fn over the selected columns of the record rec and returns the
+// record re-joined. In CSV mode fields are unquoted before being handed to fn,
+// and quoted again on the way out if they were quoted originally or now need
+// to be.
+func (f *Fields) Apply(rec []byte, fn func([]byte) []byte) []byte {
+	return f.applyAt(rec, func(b []byte, _ position) []byte {
+		return fn(b)
+	})
+}
+
+// applyAt implements Apply, telling fn where each field it's handed is found
+// in rec.
+func (f *Fields) applyAt(rec []byte, fn func([]byte, position) []byte) []byte {
+	body, eol := splitEOL(rec)
+	var buffer []byte
+	if !f.CSV {
+		off := 0
+		for i, field := range bytes.Split(body, []byte{f.Delimiter}) {
+			if i > 0 {
+				buffer = append(buffer, f.Delimiter)
+			}
+			start := off
+			off += len(field) + 1
+			if f.Selects(i + 1) {
+				field = fn(field, offsetAt(start))
+			}
+			buffer = append(buffer, field...)
+		}
+		return append(buffer, eol...)
+	}
+	for i, field := range f.splitCSV(body) {
+		if i > 0 {
+			buffer = append(buffer, f.Delimiter)
+		}
+		if f.Selects(i + 1) {
+			field.Value = fn(field.Value, field.position())
+		}
+		buffer = f.appendCSV(buffer, field)
+	}
+	return append(buffer, eol...)
+}
+
+// csvField is a single decoded CSV field, along with whether it was quoted
+// in the input and where its value starts in the record.
+type csvField struct {
+	Value  []byte
+	Quoted bool
+	Start  int
+}
+
+// position maps an offset into the value of field back to one into the
+// record, past the quotes doubled in it.
+func (field csvField) position() position {
+	value := field.Value
+	return func(off int) int {
+		if field.Quoted {
+			off += bytes.Count(value[:off], []byte{'"'})
+		}
+		return field.Start + off
+	}
+}
+
+// splitCSV decodes the fields of a single CSV record, line terminator
+// excluded. Stray quotes in unquoted fields are kept as is, like most CSV
+// producers expect.
+func (f *Fields) splitCSV(rec []byte) []csvField {
+	var fields []csvField
+	i := 0
+	for {
+		field := csvField{Value: []byte{}, Start: i}
+		if i < len(rec) && rec[i] == '"' {
+			field.Quoted, field.Start = true, i+1
+			for i++; i < len(rec); i++ {
+				if rec[i] == '"' {
+					if i+1 < len(rec) && rec[i+1] == '"' {
+						field.Value = append(field.Value, '"')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				field.Value = append(field.Value, rec[i])
+			}
+		}
+		for ; i < len(rec) && rec[i] != f.Delimiter; i++ {
+			field.Value = append(field.Value, rec[i])
+		}
+		fields = append(fields, field)
+		if i >= len(rec) {
+			return fields
+		}
+		i++
+	}
+}
+
+// appendCSV encodes field onto buffer, quoting it if it was quoted in the
+// input or its value now requires quoting.
+func (f *Fields) appendCSV(buffer []byte, field csvField) []byte {
+	if !field.Quoted && bytes.IndexAny(field.Value,
+		string([]byte{f.Delimiter, '"', '\r', '\n'})) < 0 {
+		return append(buffer, field.Value...)
+	}
+	buffer = append(buffer, '"')
+	buffer = append(buffer, bytes.ReplaceAll(field.Value, []byte(`"`),
+		[]byte(`""`))...)
+	return append(buffer, '"')
+}
+
+// readRecord reads the next record from br, starting on line n of the
+// input. This is a single line, unless in CSV mode where a quoted field may
+// carry the record over several lines. A quote left open at the end of the
+// input is an error.
+func (f *Fields) readRecord(br *bufio.Reader, n int) ([]byte, error) {
+	rec, err := br.ReadBytes('\n')
+	if f == nil || !f.CSV {
+		return rec, err
+	}
+	for err == nil && bytes.Count(rec, []byte(`"`))%2 == 1 {
+		var more []byte
+		more, err = br.ReadBytes('\n')
+		rec = append(rec, more...)
+	}
+	if err == io.EOF && bytes.Count(rec, []byte(`"`))%2 == 1 {
+		return nil, fmt.Errorf("line %d: err: unterminated quoted field", n)
+	}
+	return rec, err
+}
+
+// splitEOL splits the line terminator (\n or \r\n) off of line.
+func splitEOL(line []byte) ([]byte, []byte) {
+	switch {
+	case bytes.HasSuffix(line, []byte("\r\n")):
+		return line[:len(line)-2], line[len(line)-2:]
+	case bytes.HasSuffix(line, []byte("\n")):
+		return line[:len(line)-1], line[len(line)-1:]
+	}
+	return line, nil
+}
+
+// fields builds the Fields configured by the Fields/Delimiter/CSV flags,
+// returning nil if neither column selection nor CSV mode is enabled.
+func (f *Flags) fields() (*Fields, error) {
+	if f == nil || (f.Fields == "" && !f.CSV) {
+		return nil, nil
+	}
+	delim := byte('\t')
+	if f.CSV {
+		delim = ','
+	}
+	switch len(f.Delimiter) {
+	case 0:
+	case 1:
+		delim = f.Delimiter[0]
+	default:
+		return nil, fmt.Errorf("err: delimiter must be a single byte. got:"+
+			" %q", f.Delimiter)
+	}
+	return ParseFields(f.Fields, delim, f.CSV)
+}
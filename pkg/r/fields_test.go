@@ -0,0 +1,101 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	f, err := ParseFields("1,3-5,8-", '\t', false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for n, want := range map[int]bool{1: true, 2: false, 3: true, 5: true,
+		6: false, 8: true, 42: true} {
+		if f.Selects(n) != want {
+			t.Errorf("field %d: expected %v. got %v\n", n, want, !want)
+		}
+	}
+	for _, list := range []string{"0", "a", "3-1", "1,,2"} {
+		if _, err := ParseFields(list, '\t', false); err == nil {
+			t.Errorf("%s: expected error\n", list)
+		}
+	}
+}
+
+func TestFieldsApply(t *testing.T) {
+	test := []struct {
+		List       string
+		Delim      byte
+		CSV        bool
+		RawString  string
+		DestString string
+	}{
+		{"2", '\t', false, "ab\tcd\tef\n", "ab\tCD\tef\n"},
+		{"1,3", ':', false, "ab:cd:ef\r\n", "AB:cd:EF\r\n"},
+		{"2", ',', true, `a,"b, ""c""",d` + "\n", `a,"B, ""C""",d` + "\n"},
+		{"1", ',', true, "\"x\ny\",z\n", "\"X\nY\",z\n"},
+		{"", ',', true, "a,b\n", "A,B\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		f, err := ParseFields(test[i].List, test[i].Delim, test[i].CSV)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got := f.Apply([]byte(test[i].RawString), bytes.ToUpper)
+		if string(got) != test[i].DestString {
+			t.Errorf("expected %q. got %q\n", test[i].DestString, got)
+		}
+	}
+}
+
+func TestFieldsApplyRequote(t *testing.T) {
+	f, _ := ParseFields("1", ',', true)
+	got := f.Apply([]byte("a;b,c\n"), func(b []byte) []byte {
+		return bytes.ReplaceAll(b, []byte(";"), []byte(","))
+	})
+	if string(got) != "\"a,b\",c\n" {
+		t.Errorf("expected %q. got %q\n", "\"a,b\",c\n", got)
+	}
+}
+
+func TestStreamCSV(t *testing.T) {
+	r := R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+		DelString: "0123456789", Fields: "2", CSV: true}}
+	var out bytes.Buffer
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("1,\"a1,\nb2\",3\n4,c5,6\n"), &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "1,\"a,\nb\",3\n4,c,6\n" {
+		t.Errorf("expected %q. got %q\n", "1,\"a,\nb\",3\n4,c,6\n",
+			out.String())
+	}
+}
+
+func TestStreamCSVUnterminatedQuote(t *testing.T) {
+	r := R{Flag: &Flags{CSV: true}}
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("a,\"b\nc\",d\ne,\"f,g\nh\n"), &bytes.Buffer{})
+	expected := "line 3: err: unterminated quoted field"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q. got %v\n", expected, err)
+	}
+}
+
+func TestStreamCSVLines(t *testing.T) {
+	// the second record spans lines 2 and 3, so the third starts on line 4
+	r := R{From: []byte("a-z"), To: []byte("A-Z"),
+		Flag: &Flags{CSV: true, Lines: "4,$"}}
+	var out bytes.Buffer
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("a,b\nc,\"d\ne\"\nf,g\n"), &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "a,b\nc,\"d\ne\"\nF,G\n"; out.String() != expected {
+		t.Errorf("expected %q. got %q\n", expected, out.String())
+	}
+}
@@ -0,0 +1,289 @@
+package r
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSON restricts processing to the string values of JSON input. Everything
+// else, including whitespace and the original escaping of strings left
+// unchanged, is copied through as is, so the output stays valid JSON.
+type JSON struct {
+	// Keys enables processing of object keys along with string values
+	Keys bool
+	// Paths, if set, restricts processing to the values found at these
+	// paths. Each path is a list of object keys and array indices, where *
+	// matches any key or index.
+	Paths [][]string
+}
+
+// ParseJSONPath parses a path in the form $.items.*.name (the leading $ being
+// optional) into its segments.
+func ParseJSONPath(s string) ([]string, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "$"), ".")
+	if s == "" {
+		return nil, fmt.Errorf("err: empty JSON path")
+	}
+	path := strings.Split(s, ".")
+	for _, seg := range path {
+		if seg == "" {
+			return nil, fmt.Errorf("err: invalid JSON path %q", s)
+		}
+	}
+	return path, nil
+}
+
+// Apply runs fn over the string values of the JSON text doc. doc may hold
+// several whitespace separated values.
+func (j *JSON) Apply(doc []byte, fn func([]byte) []byte) ([]byte, error) {
+	return j.applyAt(doc, func(b []byte, _ position) []byte {
+		return fn(b)
+	})
+}
+
+// applyAt implements Apply, telling fn where each string it's handed is
+// found in doc. As its escapes don't map back byte for byte, a string is
+// found as a whole, at its opening quote.
+func (j *JSON) applyAt(doc []byte,
+	fn func([]byte, position) []byte) ([]byte, error) {
+	s := jsonScanner{j: j, src: doc, fn: fn,
+		out: make([]byte, 0, len(doc))}
+	for s.space(); s.pos < len(s.src); s.space() {
+		if err := s.value(); err != nil {
+			return nil, err
+		}
+	}
+	return s.out, nil
+}
+
+// selects reports whether the value at path is to be processed.
+func (j *JSON) selects(path []string) bool {
+	if j.Paths == nil {
+		return true
+	}
+outer:
+	for _, p := range j.Paths {
+		if len(p) != len(path) {
+			continue
+		}
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// jsonScanner copies JSON text from src to out, one value at a time, passing
+// the selected strings through fn on the way.
+type jsonScanner struct {
+	j        *JSON
+	src, out []byte
+	pos      int
+	path     []string
+	fn       func([]byte, position) []byte
+}
+
+func (s *jsonScanner) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("err: invalid JSON at offset %d: %s", s.pos,
+		fmt.Sprintf(format, a...))
+}
+
+// space copies any whitespace at the current position.
+func (s *jsonScanner) space() {
+	for s.pos < len(s.src) && bytes.IndexByte([]byte(" \t\r\n"),
+		s.src[s.pos]) >= 0 {
+		s.out = append(s.out, s.src[s.pos])
+		s.pos++
+	}
+}
+
+// expect copies the byte c, failing if it isn't the one at the current
+// position.
+func (s *jsonScanner) expect(c byte) error {
+	if s.pos >= len(s.src) {
+		return s.errorf("expected %q, got end of input", c)
+	}
+	if s.src[s.pos] != c {
+		return s.errorf("expected %q, got %q", c, s.src[s.pos])
+	}
+	s.out = append(s.out, c)
+	s.pos++
+	return nil
+}
+
+func (s *jsonScanner) value() error {
+	if s.pos >= len(s.src) {
+		return s.errorf("unexpected end of input")
+	}
+	switch s.src[s.pos] {
+	case '{':
+		return s.object()
+	case '[':
+		return s.array()
+	case '"':
+		return s.str(s.j.selects(s.path))
+	}
+	start := s.pos
+	for s.pos < len(s.src) && bytes.IndexByte([]byte(",:]} \t\r\n"),
+		s.src[s.pos]) < 0 {
+		s.pos++
+	}
+	lit := s.src[start:s.pos]
+	if !json.Valid(lit) {
+		s.pos = start
+		return s.errorf("unexpected %q", lit)
+	}
+	s.out = append(s.out, lit...)
+	return nil
+}
+
+func (s *jsonScanner) object() error {
+	s.out = append(s.out, '{')
+	s.pos++
+	s.space()
+	if s.pos < len(s.src) && s.src[s.pos] == '}' {
+		return s.expect('}')
+	}
+	for {
+		s.space()
+		if s.pos >= len(s.src) || s.src[s.pos] != '"' {
+			return s.expect('"')
+		}
+		key, err := s.rawString()
+		if err != nil {
+			return err
+		}
+		var name string
+		if err = json.Unmarshal(key, &name); err != nil {
+			return s.errorf("%s", err)
+		}
+		s.path = append(s.path, name)
+		s.pos -= len(key)
+		if err = s.str(s.j.Keys && s.j.selects(s.path)); err != nil {
+			return err
+		}
+		s.space()
+		if err = s.expect(':'); err != nil {
+			return err
+		}
+		s.space()
+		if err = s.value(); err != nil {
+			return err
+		}
+		s.path = s.path[:len(s.path)-1]
+		s.space()
+		if s.pos < len(s.src) && s.src[s.pos] == '}' {
+			return s.expect('}')
+		}
+		if err = s.expect(','); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *jsonScanner) array() error {
+	s.out = append(s.out, '[')
+	s.pos++
+	s.space()
+	if s.pos < len(s.src) && s.src[s.pos] == ']' {
+		return s.expect(']')
+	}
+	for i := 0; ; i++ {
+		s.space()
+		s.path = append(s.path, strconv.Itoa(i))
+		if err := s.value(); err != nil {
+			return err
+		}
+		s.path = s.path[:len(s.path)-1]
+		s.space()
+		if s.pos < len(s.src) && s.src[s.pos] == ']' {
+			return s.expect(']')
+		}
+		if err := s.expect(','); err != nil {
+			return err
+		}
+	}
+}
+
+// rawString consumes the string literal at the current position, returning
+// it quotes and escapes included.
+func (s *jsonScanner) rawString() ([]byte, error) {
+	start := s.pos
+	for s.pos++; s.pos < len(s.src); s.pos++ {
+		switch s.src[s.pos] {
+		case '\\':
+			s.pos++
+		case '"':
+			s.pos++
+			return s.src[start:s.pos], nil
+		}
+	}
+	s.pos = start
+	return nil, s.errorf("unterminated string")
+}
+
+// str copies the string literal at the current position. If process is set
+// the string is decoded, run through fn and encoded back; the original
+// literal is kept whenever fn leaves the value unchanged.
+func (s *jsonScanner) str(process bool) error {
+	raw, err := s.rawString()
+	if err != nil {
+		return err
+	}
+	var val string
+	if err = json.Unmarshal(raw, &val); err != nil {
+		s.pos -= len(raw)
+		return s.errorf("%s", err)
+	}
+	if !process {
+		s.out = append(s.out, raw...)
+		return nil
+	}
+	start := s.pos - len(raw)
+	res := s.fn([]byte(val), func(int) int {
+		return start
+	})
+	if string(res) == val {
+		s.out = append(s.out, raw...)
+		return nil
+	}
+	var buffer bytes.Buffer
+	enc := json.NewEncoder(&buffer)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(string(res)); err != nil {
+		return err
+	}
+	s.out = append(s.out, bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))...)
+	return nil
+}
+
+// json builds the JSON configured by the JSON/JSONL/JSONKeys/JSONPath flags,
+// returning nil if JSON mode isn't enabled.
+func (f *Flags) json() (*JSON, error) {
+	if f == nil || (!f.JSON && !f.JSONL) {
+		return nil, nil
+	}
+	switch {
+	case f.JSON && f.JSONL:
+		return nil, fmt.Errorf("err: --json and --jsonl are mutually" +
+			" exclusive")
+	case f.Fields != "" || f.CSV:
+		return nil, fmt.Errorf("err: --json/--jsonl can't be combined with" +
+			" --fields or --csv")
+	}
+	j := &JSON{Keys: f.JSONKeys}
+	for _, p := range f.JSONPath {
+		path, err := ParseJSONPath(p)
+		if err != nil {
+			return nil, err
+		}
+		j.Paths = append(j.Paths, path)
+	}
+	return j, nil
+}
@@ -0,0 +1,90 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONApply(t *testing.T) {
+	test := []struct {
+		Keys       bool
+		Paths      []string
+		RawString  string
+		DestString string
+	}{
+		{false, nil, `{"ab": "cd", "n": [1, "ef"]}`, `{"ab": "CD", "n": [1, "EF"]}`},
+		{true, nil, `{"ab": "cd"}`, `{"AB": "CD"}`},
+		{false, nil, `"a\"b"`, `"A\"B"`},
+		{false, nil, `"1"`, `"1"`},
+		{false, []string{"$.a.*.b"}, `{"a": [{"b": "x", "c": "y"}], "b": "z"}`,
+			`{"a": [{"b": "X", "c": "y"}], "b": "z"}`},
+		{false, nil, "{}\n[]\n\"a\"", "{}\n[]\n\"A\""},
+	}
+	for i := 0; i < len(test); i++ {
+		j := &JSON{Keys: test[i].Keys}
+		for _, p := range test[i].Paths {
+			path, err := ParseJSONPath(p)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			j.Paths = append(j.Paths, path)
+		}
+		got, err := j.Apply([]byte(test[i].RawString), bytes.ToUpper)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if string(got) != test[i].DestString {
+			t.Errorf("expected %s. got %s\n", test[i].DestString, got)
+		}
+	}
+}
+
+func TestJSONApplyInvalid(t *testing.T) {
+	for _, doc := range []string{`{"a" 1}`, `[1,]`, `"abc`, `{"a": tru}`,
+		`[1 2]`} {
+		if _, err := (&JSON{}).Apply([]byte(doc), bytes.ToUpper); err == nil {
+			t.Errorf("%s: expected error\n", doc)
+		}
+	}
+}
+
+func TestStreamJSONL(t *testing.T) {
+	r := R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+		DelString: `"\\{}`, JSONL: true}}
+	var out bytes.Buffer
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("{\"m\": \"a\\\"b\\\\c{}\"}\n{\"m\": \"ok\"}\n"),
+		&out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, line := range bytes.Split(bytes.TrimSpace(out.Bytes()),
+		[]byte("\n")) {
+		if !json.Valid(line) {
+			t.Errorf("invalid JSON output: %s\n", line)
+		}
+	}
+	if out.String() != "{\"m\": \"abc\"}\n{\"m\": \"ok\"}\n" {
+		t.Errorf("expected %q. got %q\n", "{\"m\": \"abc\"}\n{\"m\": \"ok\"}\n",
+			out.String())
+	}
+}
+
+func TestStreamJSONAddress(t *testing.T) {
+	for _, f := range []Flags{
+		{JSON: true, Lines: "2"},
+		{JSON: true, Match: "x"},
+		{JSON: true, NotMatch: "x"},
+	} {
+		r := R{From: []byte("a"), To: []byte("b"), Flag: &f}
+		err := r.Stream(context.Background(),
+			bytes.NewBufferString("{\"a\": \"a\"}\n"), &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "--json") {
+			t.Errorf("%+v: expected error. got %v\n", f, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package r
+
+import "fmt"
+
+// limit selects the matches the operation configured on r acts on, that is
+// the characters it translates or deletes, or the strings it replaces: only
+// the occurrence-th match of every line, and no more than max matches, in
+// total or on every line with perLine. Matches left alone pass through as
+// they are.
+type limit struct {
+	max, occurrence int
+	perLine         bool
+	// acted counts the matches acted on, in total and on the current line.
+	// seen counts the matches of the current line.
+	acted, lineActed, seen int
+}
+
+// next reports whether the operation acts on the next match. A nil limit
+// selects every match.
+func (l *limit) next() bool {
+	if l == nil {
+		return true
+	}
+	l.seen++
+	if l.occurrence > 0 && l.seen != l.occurrence {
+		return false
+	}
+	if l.max > 0 && ((l.perLine && l.lineActed >= l.max) ||
+		(!l.perLine && l.acted >= l.max)) {
+		return false
+	}
+	l.acted++
+	l.lineActed++
+	return true
+}
+
+// newLine starts counting the matches of a new line.
+func (l *limit) newLine() {
+	if l != nil {
+		l.seen, l.lineActed = 0, 0
+	}
+}
+
+// limit returns the limit selected by the flags, or nil.
+func (f *Flags) limit() (*limit, error) {
+	if f == nil || (f.Max == 0 && f.Occurrence == 0) {
+		if f != nil && f.PerLine {
+			return nil, fmt.Errorf("err: --per-line needs --max")
+		}
+		return nil, nil
+	}
+	if f.Max < 0 || f.Occurrence < 0 {
+		return nil, fmt.Errorf("err: --max and --occurrence must be positive")
+	}
+	if f.Action == Action_SQUEEZE {
+		return nil, fmt.Errorf("err: --max and --occurrence don't apply to " +
+			"squeezing")
+	}
+	return &limit{max: f.Max, occurrence: f.Occurrence, perLine: f.PerLine},
+		nil
+}
@@ -0,0 +1,62 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestStreamLimit(t *testing.T) {
+	test := []struct {
+		R        R
+		Flags    Flags
+		Raw      string
+		Expected string
+	}{
+		{R{From: []byte(","), To: []byte(";")}, Flags{Occurrence: 2},
+			"a,b,c\nd,e,f\n", "a,b;c\nd,e;f\n"},
+		{R{From: []byte(","), To: []byte(";")}, Flags{Max: 3},
+			"a,b,c\nd,e,f\n", "a;b;c\nd;e,f\n"},
+		{R{From: []byte("a-c"), To: []byte("x-z")},
+			Flags{Max: 1, PerLine: true}, "abc\ncba\n", "xbc\nzba\n"},
+		{R{FlagEnabled: true}, Flags{Action: Action_DELETE, DelString: ",",
+			Occurrence: 1}, "a,b,c\n,\n", "ab,c\n\n"},
+		{R{From: []byte("foo"), To: []byte("bar")},
+			Flags{Occurrence: 2, Max: 1}, "foo foo foo\nfoo foo\n",
+			"foo bar foo\nfoo foo\n"},
+		// the rune engine
+		{R{From: []byte("é"), To: []byte("e")}, Flags{Max: 2},
+			"é,é\né\n", "e,e\né\n"},
+		{R{FlagEnabled: true}, Flags{Action: Action_DELETE,
+			DelString: `\p{Greek}`, Occurrence: 2}, "αβγ\nδε\n", "αγ\nδ\n"},
+		{R{From: []byte("foo"), To: []byte("bar")},
+			Flags{IgnoreCase: true, Occurrence: 2}, "FOO Foo\n", "FOO bar\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &test[i].R
+		r.Flag = &test[i].Flags
+		out := &bytes.Buffer{}
+		err := r.Stream(context.Background(), bytes.NewBufferString(test[i].Raw),
+			out)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if out.String() != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, out)
+		}
+	}
+}
+
+func TestLimitFlags(t *testing.T) {
+	for _, f := range []Flags{
+		{PerLine: true},
+		{Max: -1},
+		{Occurrence: -2},
+		{Action: Action_SQUEEZE, SqueezeBytes: []byte("a"), Max: 1},
+	} {
+		if _, err := f.limit(); err == nil {
+			t.Errorf("%+v: expected error\n", f)
+		}
+	}
+}
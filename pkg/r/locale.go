@@ -0,0 +1,106 @@
+package r
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+// localeClasses are the POSIX classes as they stand outside of the C
+// locale, resolved through Unicode. Classes not listed keep their C
+// definition.
+var localeClasses = map[string][]*unicode.RangeTable{
+	"upper": {unicode.Upper},
+	"lower": {unicode.Lower},
+	"alpha": {unicode.Letter},
+	"alnum": {unicode.Letter, unicode.Nd},
+	"space": {unicode.White_Space},
+}
+
+// Locale holds the character classes and case mappings of a locale.
+type Locale struct {
+	// Name is the locale as given, eg: tr_TR.UTF-8
+	Name string
+	// Tag is the language of the locale. It's undefined for C.
+	Tag language.Tag
+}
+
+// CLocale is the C (or POSIX) locale, where classes and case mappings only
+// cover ASCII and work byte by byte.
+var CLocale = &Locale{Name: "C", Tag: language.Und}
+
+// DefaultLocale returns the locale selected by the environment, looking at
+// LC_ALL, LC_CTYPE and LANG in turn.
+func DefaultLocale() string {
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return "C"
+}
+
+// ParseLocale parses a locale name in the form language_TERRITORY.codeset,
+// eg: tr_TR.UTF-8. C, POSIX and C.UTF-8 all give CLocale.
+func ParseLocale(name string) (*Locale, error) {
+	base, _, _ := strings.Cut(name, "@")
+	base, _, _ = strings.Cut(base, ".")
+	if base == "" || base == "C" || base == "POSIX" {
+		return CLocale, nil
+	}
+	tag, err := language.Parse(strings.ReplaceAll(base, "_", "-"))
+	if err != nil {
+		return nil, fmt.Errorf("err: unknown locale %q: %w", name, err)
+	}
+	return &Locale{Name: name, Tag: tag}, nil
+}
+
+// IsC reports whether l is the C locale.
+func (l *Locale) IsC() bool {
+	return l == nil || l == CLocale
+}
+
+// turkic reports whether l uses the Turkish dotted and dotless i mappings.
+func (l *Locale) turkic() bool {
+	if l.IsC() {
+		return false
+	}
+	base, _ := l.Tag.Base()
+	return base.String() == "tr" || base.String() == "az"
+}
+
+// ToUpper maps c to upper case.
+func (l *Locale) ToUpper(c rune) rune {
+	if l.turkic() {
+		return unicode.TurkishCase.ToUpper(c)
+	}
+	return unicode.ToUpper(c)
+}
+
+// ToLower maps c to lower case.
+func (l *Locale) ToLower(c rune) rune {
+	if l.turkic() {
+		return unicode.TurkishCase.ToLower(c)
+	}
+	return unicode.ToLower(c)
+}
+
+// class resolves the POSIX class name as it stands in l.
+func (l *Locale) class(name string) ([]*unicode.RangeTable, bool) {
+	if l.IsC() {
+		return nil, false
+	}
+	t, ok := localeClasses[name]
+	return t, ok
+}
+
+// locale returns the locale selected by the flags.
+func (f *Flags) locale() (*Locale, error) {
+	if f == nil || f.Locale == "" {
+		return CLocale, nil
+	}
+	return ParseLocale(f.Locale)
+}
@@ -0,0 +1,99 @@
+package r
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseLocale(t *testing.T) {
+	test := []struct {
+		Name string
+		C    bool
+		Base string
+	}{
+		{"", true, ""},
+		{"C", true, ""},
+		{"POSIX", true, ""},
+		{"C.UTF-8", true, ""},
+		{"tr_TR.UTF-8", false, "tr"},
+		{"de_DE@euro", false, "de"},
+		{"az", false, "az"},
+	}
+	for i := 0; i < len(test); i++ {
+		l, err := ParseLocale(test[i].Name)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test[i].Name, err)
+			continue
+		}
+		if l.IsC() != test[i].C {
+			t.Errorf("%s: expected C to be %v\n", test[i].Name, test[i].C)
+			continue
+		}
+		if base, _ := l.Tag.Base(); !l.IsC() && base.String() != test[i].Base {
+			t.Errorf("%s: expected language %q. got %q\n", test[i].Name,
+				test[i].Base, base)
+		}
+	}
+	if _, err := ParseLocale("zz_!!"); err == nil {
+		t.Errorf("expected error for malformed locale")
+	}
+}
+
+func TestLocaleClasses(t *testing.T) {
+	fr, _ := ParseLocale("fr_FR.UTF-8")
+	s, err := fr.ParseRuneSet("[:upper:]")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !s.Has('É') || !s.Has('A') || s.Has('é') {
+		t.Errorf("expected [:upper:] to hold É and A but not é under fr_FR")
+	}
+	if s, _ = ParseRuneSet("[:upper:]"); s.Has('É') {
+		t.Errorf("expected [:upper:] not to hold É under C")
+	}
+}
+
+func TestChurnLocale(t *testing.T) {
+	test := []struct {
+		Locale     string
+		From, To   string
+		RawString  string
+		DestString string
+	}{
+		{"tr_TR", "[:lower:]", "[:upper:]", "iı ğ", "İI Ğ"},
+		{"tr_TR", "[:upper:]", "[:lower:]", "İI Ş", "iı ş"},
+		{"de_DE", "[:lower:]", "[:upper:]", "iı ä", "II Ä"},
+		// C works byte by byte and only knows of ASCII
+		{"C", "[:lower:]", "[:upper:]", "iä", "Iä"},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &R{From: []byte(test[i].From), To: []byte(test[i].To),
+			Flag: &Flags{Locale: test[i].Locale}, RawString: test[i].RawString}
+		r.Churn(context.Background())
+		if r.DestString != test[i].DestString {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].DestString,
+				r.DestString)
+		}
+	}
+	r := &R{Flag: &Flags{Locale: "C"}, From: []byte("[:upper:]"),
+		To: []byte("[:lower:]")}
+	if r.runeMode() {
+		t.Errorf("expected C to keep to the byte engine")
+	}
+}
+
+func TestApplyCompiledLocale(t *testing.T) {
+	for _, f := range []Flags{{Locale: "tr_TR"}, {Locale: "tr_TR",
+		IgnoreCase: true}} {
+		r := &R{From: []byte("i"), To: []byte("x"), Flag: &f}
+		if err := r.compile(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// the locale is resolved once, not on every line
+		f.Locale = "no such locale"
+		got := r.Apply(context.Background(), []byte("İi"))
+		if string(got) != "İx" {
+			t.Errorf("%+v: expected %q. got %q\n", f, "İx", got)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package r
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaskEmail = "email"
+	MaskCard  = "card"
+	MaskIPv4  = "ipv4"
+	MaskIPv6  = "ipv6"
+	MaskToken = "token"
+	MaskAWS   = "aws"
+	// MaskAll stands for every detector
+	MaskAll = "all"
+	// MaskFormat masks a span keeping its format: digits become #, letters
+	// become x and anything else is kept
+	MaskFormat = "format"
+)
+
+// maskDetector finds spans of personal or secret data in a line.
+type maskDetector struct {
+	name string
+	re   *regexp.Regexp
+	// valid, if set, tells the matches of re from false positives
+	valid func(span []byte) bool
+}
+
+// maskDetectors are the detectors, in the order they run: every one sees
+// the line as masked by those before it.
+var maskDetectors = []maskDetector{
+	{MaskEmail, regexp.MustCompile(
+		`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+		nil},
+	{MaskAWS, regexp.MustCompile(
+		`\b(?:AKIA|ASIA|AIDA|AROA|ANPA|ABIA|ACCA)[A-Z0-9]{16}\b`), nil},
+	// card numbers are written in groups of 4, or 4, 6 and 5 digits, or in
+	// one piece
+	{MaskCard, regexp.MustCompile(`\b(?:[0-9]{4}[ -]){3}[0-9]{1,7}\b|` +
+		`\b[0-9]{4}[ -][0-9]{6}[ -][0-9]{5}\b|\b[0-9]{13,19}\b`), luhn},
+	// IPv6 candidates hold a colon. They take in whole words around it, so
+	// that the hex digits of a word like std::map aren't taken for one.
+	{MaskIPv6, regexp.MustCompile(`[\w:.]*:[\w:.]*[\w:]`),
+		func(span []byte) bool {
+			addr, err := netip.ParseAddr(string(span))
+			return err == nil && addr.Is6() &&
+				bytes.ContainsAny(span, "0123456789abcdefABCDEF")
+		}},
+	{MaskIPv4, regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|` +
+		`[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\b`),
+		nil},
+	{MaskToken, regexp.MustCompile(
+		`\b[0-9A-Fa-f]{32,}\b|[A-Za-z0-9+/]{32,}={0,2}`), token},
+}
+
+// luhn reports whether the digits of span pass the Luhn check of credit
+// card numbers.
+func luhn(span []byte) bool {
+	sum, n := 0, 0
+	for i := len(span) - 1; i >= 0; i-- {
+		if span[i] < '0' || span[i] > '9' {
+			continue
+		}
+		d := int(span[i] - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// token reports whether span looks like a secret token rather than a long
+// word: either hex, or base64 mixing upper and lower case letters and
+// digits.
+func token(span []byte) bool {
+	hex, upper, lower, digit := true, false, false, false
+	for _, c := range span {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+			hex = hex && c <= 'F'
+		case c >= 'a' && c <= 'z':
+			lower = true
+			hex = hex && c <= 'f'
+		default:
+			hex = false
+		}
+	}
+	return hex || (upper && lower && digit)
+}
+
+// NewMasker returns a Stage masking the personal and secret data found by
+// the detectors of spec, line by line. spec is a comma separated list of
+// detectors, each one of email, card (credit card numbers passing the Luhn
+// check), ipv4, ipv6, token (long hex or base64 strings), aws (AWS access
+// key ids) or all, and optionally followed by = and its mask: format
+// (the default), or a fixed token replacing the whole span, eg:
+// all,email=<email>. The spans masked are counted into stats, which may be
+// nil, by detector.
+func NewMasker(spec string, stats *Stats) (Stage, error) {
+	masks := map[string]string{}
+	for _, item := range strings.Split(spec, ",") {
+		name, mask, ok := strings.Cut(item, "=")
+		if !ok {
+			mask = MaskFormat
+		}
+		found := false
+		for _, d := range maskDetectors {
+			if name == d.name || name == MaskAll {
+				masks[d.name], found = mask, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("err: unknown mask detector %q. expecting "+
+				"%s", name, strings.Join(maskNames(), ", "))
+		}
+	}
+	return &lineStage{fn: func(dst, line []byte) []byte {
+		for _, d := range maskDetectors {
+			mask, ok := masks[d.name]
+			if !ok {
+				continue
+			}
+			line = d.re.ReplaceAllFunc(line, func(span []byte) []byte {
+				if d.valid != nil && !d.valid(span) {
+					return span
+				}
+				stats.masked(d.name)
+				if mask != MaskFormat {
+					return []byte(mask)
+				}
+				return maskFormat(span)
+			})
+		}
+		return append(dst, line...)
+	}}, nil
+}
+
+// maskNames returns the names NewMasker accepts for the detectors.
+func maskNames() []string {
+	names := []string{MaskAll}
+	for _, d := range maskDetectors {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+// maskFormat masks span keeping its format, see MaskFormat.
+func maskFormat(span []byte) []byte {
+	masked := make([]byte, 0, len(span))
+	for _, c := range string(span) {
+		switch {
+		case unicode.IsDigit(c):
+			masked = append(masked, '#')
+		case unicode.IsLetter(c):
+			masked = append(masked, 'x')
+		default:
+			masked = utf8.AppendRune(masked, c)
+		}
+	}
+	return masked
+}
+
+func init() {
+	Register(Registration{Name: "mask", MaxArgs: 1,
+		Usage: "mask [DETECTORS]", Defaults: []string{MaskAll},
+		New: func(args []string, env *Env) (Stage, error) {
+			return NewMasker(args[0], env.Stats)
+		}})
+}
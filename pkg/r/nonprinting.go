@@ -0,0 +1,56 @@
+package r
+
+import "fmt"
+
+// NonPrinting is a Stage making the bytes of its output visible, the way
+// cat -A does. Line ends are shown as $. With Style ControlsCaret, control
+// bytes are shown as ^X (tabs as ^I), DEL as ^? and bytes past ASCII as M-
+// followed by the byte less 0x80. With Style ControlsHex, they're all shown
+// as \xNN.
+type NonPrinting struct {
+	Style string
+}
+
+// ParseNonPrinting returns a NonPrinting stage rendering bytes in style,
+// either caret or hex.
+func ParseNonPrinting(style string) (*NonPrinting, error) {
+	if style != ControlsCaret && style != ControlsHex {
+		return nil, fmt.Errorf("err: unknown nonprinting style %q. "+
+			"expecting %s or %s", style, ControlsCaret, ControlsHex)
+	}
+	return &NonPrinting{Style: style}, nil
+}
+
+// Process implements Stage.
+func (p *NonPrinting) Process(b []byte) []byte {
+	buffer := make([]byte, 0, len(b))
+	for _, c := range b {
+		switch {
+		case c == '\n':
+			buffer = append(buffer, '$', '\n')
+		case c >= 0x20 && c < 0x7f:
+			buffer = append(buffer, c)
+		case p.Style == ControlsHex:
+			buffer = append(buffer, fmt.Sprintf("\\x%02x", c)...)
+		default:
+			if c >= 0x80 {
+				buffer = append(buffer, "M-"...)
+				c -= 0x80
+			}
+			switch {
+			case c == 0x7f:
+				buffer = append(buffer, "^?"...)
+			case c < 0x20:
+				buffer = append(buffer, '^', c+0x40)
+			default:
+				buffer = append(buffer, c)
+			}
+		}
+	}
+	return buffer
+}
+
+// Flush implements Stage.
+func (p *NonPrinting) Flush() []byte {
+	return nil
+}
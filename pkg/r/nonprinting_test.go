@@ -0,0 +1,51 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestNonPrinting(t *testing.T) {
+	test := []struct {
+		Style    string
+		Raw      string
+		Expected string
+	}{
+		{ControlsCaret, "a\tb\x01\x7f\r\n", "a^Ib^A^?^M$\n"},
+		{ControlsCaret, "é\x80\xff\x8a", "M-CM-)M-^@M-^?M-^J"},
+		{ControlsHex, "a\tb\x7f\xc3\xa9\n", `a\x09b\x7f\xc3\xa9` + "$\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		p, err := ParseNonPrinting(test[i].Style)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if got := runStage(p, []byte(test[i].Raw), 2); string(got) !=
+			test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, got)
+		}
+	}
+	if _, err := ParseNonPrinting("octal"); err == nil {
+		t.Errorf("expected error for unknown style")
+	}
+}
+
+func TestStreamNonPrinting(t *testing.T) {
+	// with no SET, the output is shown as it is
+	f := &Flags{ShowNonPrinting: ControlsCaret, EOL: EOL_CRLF}
+	if !f.Standalone() {
+		t.Errorf("expected --show-nonprinting to stand alone")
+	}
+	r := R{Flag: f}
+	out := &bytes.Buffer{}
+	err := r.Stream(context.Background(), bytes.NewBufferString("a\tb\n"), out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the stage comes last, so it shows the line ends --eol settles on
+	if expected := "a^Ib^M$\n"; out.String() != expected {
+		t.Errorf("expected %q. got %q\n", expected, out.String())
+	}
+}
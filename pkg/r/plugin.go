@@ -0,0 +1,241 @@
+package r
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultPluginTimeout is how long a plugin has to answer a frame, unless
+// told otherwise.
+const DefaultPluginTimeout = 10 * time.Second
+
+// maxPluginFrame bounds the length of the frames a plugin may answer with,
+// so that a plugin out of sync is told apart from one sending a lot.
+const maxPluginFrame = 64 << 20
+
+// Plugin is a Stage handing its input to an external process, chunk by
+// chunk, and passing on what the process answers. Chunks travel in frames
+// made of their length, as 4 bytes in big-endian order, followed by the
+// bytes of the chunk. The plugin answers every frame it reads from its
+// standard input with exactly one frame on its standard output, which may
+// be empty. A frame of length 0 marks the end of the input: the plugin
+// answers it with whatever it held back, and exits.
+//
+// The process is started on the first chunk. If it doesn't answer a frame
+// within Timeout, or exits with an error, it's killed and Err reports why,
+// along with what it wrote to its standard error.
+type Plugin struct {
+	// Command is the plugin and its arguments
+	Command []string
+	Timeout time.Duration
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	// pipe is the pipe stdout reads from
+	pipe   io.ReadCloser
+	stderr bytes.Buffer
+	err    error
+}
+
+// NewPlugin returns a Plugin running command, which it checks can be found.
+// A zero timeout stands for DefaultPluginTimeout.
+func NewPlugin(command []string, timeout time.Duration) (*Plugin, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("err: no plugin given")
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, fmt.Errorf("err: plugin %q: %w", command[0], err)
+	}
+	if timeout <= 0 {
+		timeout = DefaultPluginTimeout
+	}
+	return &Plugin{Command: command, Timeout: timeout}, nil
+}
+
+// Process implements Stage.
+func (p *Plugin) Process(b []byte) []byte {
+	if len(b) == 0 || p.err != nil {
+		return nil
+	}
+	if p.cmd == nil {
+		if p.err = p.start(); p.err != nil {
+			return nil
+		}
+	}
+	return p.roundTrip(b)
+}
+
+// Flush implements Stage. It ends the input of the plugin, returning its
+// last frame, and waits for it to exit.
+func (p *Plugin) Flush() []byte {
+	if p.cmd == nil || p.err != nil {
+		return nil
+	}
+	b := p.roundTrip(nil)
+	if p.err != nil {
+		return nil
+	}
+	p.stdin.Close()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- p.cmd.Wait()
+	}()
+	select {
+	case err := <-exited:
+		if err != nil {
+			p.err = p.failure(err)
+			return nil
+		}
+	case <-time.After(p.Timeout):
+		p.cmd.Process.Kill()
+		<-exited
+		p.err = p.failure(fmt.Errorf("still running %s after the end of "+
+			"the input", p.Timeout))
+		return nil
+	}
+	return b
+}
+
+// Err returns the error that stopped the plugin, if any.
+func (p *Plugin) Err() error {
+	return p.err
+}
+
+// start starts the plugin process.
+func (p *Plugin) start() error {
+	p.cmd = exec.Command(p.Command[0], p.Command[1:]...)
+	p.cmd.Stderr = &p.stderr
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("err: plugin %q: %w", p.Command[0], err)
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("err: plugin %q: %w", p.Command[0], err)
+	}
+	if err = p.cmd.Start(); err != nil {
+		return fmt.Errorf("err: plugin %q: %w", p.Command[0], err)
+	}
+	p.stdin, p.stdout, p.pipe = stdin, bufio.NewReader(stdout), stdout
+	return nil
+}
+
+// roundTrip sends b to the plugin in a frame, and returns the frame it
+// answers with.
+func (p *Plugin) roundTrip(b []byte) []byte {
+	type answer struct {
+		b   []byte
+		err error
+	}
+	answered := make(chan answer, 1)
+	go func() {
+		var header [4]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(b)))
+		if _, err := p.stdin.Write(append(header[:], b...)); err != nil {
+			answered <- answer{err: err}
+			return
+		}
+		if _, err := io.ReadFull(p.stdout, header[:]); err != nil {
+			answered <- answer{err: err}
+			return
+		}
+		n := binary.BigEndian.Uint32(header[:])
+		if n > maxPluginFrame {
+			answered <- answer{err: fmt.Errorf("answered with a frame of "+
+				"%d bytes, out of sync", n)}
+			return
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(p.stdout, b)
+		answered <- answer{b, err}
+	}()
+	var err error
+	select {
+	case a := <-answered:
+		if a.err == nil {
+			return a.b
+		}
+		err = a.err
+	case <-time.After(p.Timeout):
+		// the exchange is over once the plugin is killed and its pipes are
+		// closed, which must be waited for before stop closes them for good
+		p.kill()
+		<-answered
+		err = fmt.Errorf("no answer within %s", p.Timeout)
+	}
+	p.stop(err)
+	return nil
+}
+
+// kill kills the plugin and closes its pipes, so that an exchange blocked on
+// them, even by a process it left behind, is cut short.
+func (p *Plugin) kill() {
+	p.cmd.Process.Kill()
+	p.stdin.Close()
+	p.pipe.Close()
+}
+
+// stop kills the plugin, which failed with err. No exchange must be going on
+// with it, as waiting for it closes its pipes.
+func (p *Plugin) stop(err error) {
+	p.kill()
+	if werr := p.cmd.Wait(); werr != nil && !killed(werr) {
+		// the plugin exiting is what broke the exchange
+		err = werr
+	}
+	p.err = p.failure(err)
+}
+
+// killed reports whether err is that of a process killed by a signal.
+func killed(err error) bool {
+	var exit *exec.ExitError
+	return errors.As(err, &exit) && exit.ExitCode() == -1
+}
+
+// failure returns the error reporting that the plugin failed with err,
+// along with the end of what it wrote to its standard error.
+func (p *Plugin) failure(err error) error {
+	msg := strings.TrimSpace(p.stderr.String())
+	if len(msg) > 1024 {
+		msg = "..." + msg[len(msg)-1024:]
+	}
+	if msg == "" {
+		return fmt.Errorf("err: plugin %q: %w", p.Command[0], err)
+	}
+	return fmt.Errorf("err: plugin %q: %w: %s", p.Command[0], err, msg)
+}
+
+// plugins returns the pipeline of the plugins given by --plugin, each
+// written like the arguments of a line of a script, or nil.
+func (f *Flags) plugins() (Pipeline, error) {
+	var p Pipeline
+	for _, spec := range f.Plugins {
+		command, err := splitScriptLine(spec)
+		if err != nil {
+			return nil, fmt.Errorf("--plugin %q: %w", spec, err)
+		}
+		plugin, err := NewPlugin(command, f.PluginTimeout)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, plugin)
+	}
+	return p, nil
+}
+
+func init() {
+	Register(Registration{Name: "plugin", MinArgs: 1,
+		MaxArgs: math.MaxInt32, Usage: "plugin COMMAND [ARGS...]",
+		New: func(args []string, env *Env) (Stage, error) {
+			return NewPlugin(args, env.PluginTimeout)
+		}})
+}
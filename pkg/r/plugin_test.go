@@ -0,0 +1,132 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildPlugin builds the stub plugin of testdata/plugin, returning its path.
+func buildPlugin(t *testing.T) string {
+	t.Helper()
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go toolchain to build the stub plugin")
+	}
+	bin := filepath.Join(t.TempDir(), "plugin")
+	out, err := exec.Command(gobin, "build", "-o", bin, "./testdata/plugin").
+		CombinedOutput()
+	if err != nil {
+		t.Fatalf("building the stub plugin: %s: %s", err, out)
+	}
+	return bin
+}
+
+func TestPlugin(t *testing.T) {
+	bin := buildPlugin(t)
+	test := []struct {
+		Mode          string
+		Raw, Expected string
+	}{
+		{"upper", "hello\nworld\n", "HELLO\nWORLD\n"},
+		{"upper", "", ""},
+		{"hold", "held back\nuntil the end", "held back\nuntil the end"},
+	}
+	for i := 0; i < len(test); i++ {
+		for _, size := range []int{1, 4, 1024} {
+			p, err := NewPlugin([]string{bin, test[i].Mode}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := runStage(p, []byte(test[i].Raw), size)
+			if p.Err() != nil {
+				t.Errorf("%d/%d: unexpected error: %s", i, size, p.Err())
+				continue
+			}
+			if string(got) != test[i].Expected {
+				t.Errorf("%d/%d: expected %q. got %q\n", i, size,
+					test[i].Expected, got)
+			}
+		}
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	bin := buildPlugin(t)
+	test := []struct {
+		Mode     string
+		Expected []string
+	}{
+		{"fail", []string{"exit status 3", "refusing to work"}},
+		{"hang", []string{"no answer within 200ms"}},
+		{"garbage", []string{"out of sync"}},
+	}
+	for i := 0; i < len(test); i++ {
+		p, err := NewPlugin([]string{bin, test[i].Mode}, 200*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runStage(p, []byte("some input\n"), 1024)
+		if p.Err() == nil {
+			t.Errorf("%d: expected error\n", i)
+			continue
+		}
+		for _, s := range test[i].Expected {
+			if !strings.Contains(p.Err().Error(), s) {
+				t.Errorf("%d: expected %q in %q\n", i, s, p.Err())
+			}
+		}
+	}
+	if _, err := NewPlugin([]string{"no-such-plugin-anywhere"}, 0); err == nil {
+		t.Errorf("expected error for a missing plugin\n")
+	}
+}
+
+func TestStreamPlugin(t *testing.T) {
+	bin := quoteScriptArg(buildPlugin(t))
+	test := []struct {
+		R        R
+		Flags    Flags
+		Raw      string
+		Expected string
+	}{
+		{R{}, Flags{Plugins: []string{bin}}, "abc\n", "ABC\n"},
+		// the plugin runs ahead of the operation
+		{R{From: []byte("B"), To: []byte("x")},
+			Flags{Plugins: []string{bin + " upper"}}, "abc\nb\n", "AxC\nx\n"},
+		{R{}, Flags{Stages: []string{"plugin " + bin + " hold",
+			"replace o 0"}}, "one\ntwo\n", "0ne\ntw0\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &test[i].R
+		r.Flag = &test[i].Flags
+		out := &bytes.Buffer{}
+		err := r.Stream(context.Background(), bytes.NewBufferString(test[i].Raw),
+			out)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if out.String() != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, out)
+		}
+	}
+	r := &R{Flag: &Flags{Plugins: []string{bin + " fail"}}}
+	err := r.Stream(context.Background(), bytes.NewBufferString("abc\n"),
+		&bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "refusing to work") {
+		t.Errorf("expected the plugin's error. got %v\n", err)
+	}
+	// --plugin-timeout holds for plugins run by --stage too
+	r = &R{Flag: &Flags{Stages: []string{"plugin " + bin + " hang"},
+		PluginTimeout: 200 * time.Millisecond}}
+	err = r.Stream(context.Background(), bytes.NewBufferString("abc\n"),
+		&bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "no answer within 200ms") {
+		t.Errorf("expected the plugin to time out. got %v\n", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 )
 
 const (
@@ -42,6 +43,25 @@ type R struct {
 	FlagEnabled bool
 	// Flags defines the flags that can be set during starttime
 	Flag *Flags
+	// Stats, if set, collects statistics on the changes made to the input
+	Stats *Stats
+	// compiled is set once compile has readied the operation, caching loc,
+	// runes, runeOp and matcher
+	compiled bool
+	// loc is the locale of the operation
+	loc *Locale
+	// runes is whether the operation runs on the rune engine, see runeMode
+	runes bool
+	// runeOp caches the operation compiled for the rune engine
+	runeOp *runeOp
+	// matcher caches the matcher of a string substitution
+	matcher *matcher
+	// limit selects the matches the operation acts on
+	limit *limit
+	// squeeze carries a run of repeats being squeezed across calls of Apply
+	squeeze *squeezeRun
+	// marks, if set, records where the operation matches, see Check
+	marks *marks
 	// Embedded struct to control mutation of struct resource
 	sync.Mutex
 }
@@ -58,6 +78,129 @@ type Flags struct {
 	SqueezeString string
 	// Action defines what mode of flag action is enabled
 	Action int
+	// Complement applies the operation to the characters not in SET1
+	Complement bool
+	// Within restricts processing to the parts of each line matching the regex
+	Within string
+	// Outside restricts processing to the parts of each line not matching the
+	// regex
+	Outside string
+	// Group names the capture group of Within/Outside that delimits the scope
+	Group string
+	// Lines restricts processing to a range of lines, eg: 1,10 or 5,$. A CSV
+	// record is addressed by the line it starts on
+	Lines string
+	// Match restricts processing to lines matching the regex
+	Match string
+	// NotMatch restricts processing to lines not matching the regex
+	NotMatch string
+	// Fields restricts processing to a list of columns, eg: 1,3-5
+	Fields string
+	// Delimiter separates the columns selected by Fields
+	Delimiter string
+	// CSV parses the columns as RFC 4180 CSV
+	CSV bool
+	// JSON restricts processing to the string values of a JSON document
+	JSON bool
+	// JSONL restricts processing to the string values of newline delimited
+	// JSON
+	JSONL bool
+	// JSONKeys extends JSON processing to object keys
+	JSONKeys bool
+	// JSONPath restricts JSON processing to the values at these paths
+	JSONPath []string
+	// Stats selects the format, if any, of the statistics reported on stderr
+	Stats string
+	// Diff outputs a unified diff of the changes instead of the result
+	Diff bool
+	// Highlight outputs the result with the changes colourised
+	Highlight bool
+	// Count outputs how many characters of the input are in SET1
+	Count bool
+	// Check outputs the characters the operation would change, exiting 1 if
+	// there are any
+	Check bool
+	// CheckLimit caps how many offending characters Check lists
+	CheckLimit int
+	// Sanitize lists the options of the sanitize stage, eg: ansi,controls
+	Sanitize string
+	// EOL selects the line ending the output is normalised to
+	EOL string
+	// DetectEOL outputs the mix of line endings found in the input
+	DetectEOL bool
+	// Normalize selects the Unicode normalization form of the input
+	Normalize string
+	// NormalizeOutput selects the Unicode normalization form of the output
+	NormalizeOutput string
+	// Fold applies full Unicode case folding to the input
+	Fold bool
+	// Case maps the output to upper, lower or title case
+	Case string
+	// ToASCII transliterates the output to plain ASCII
+	ToASCII bool
+	// ASCIIReplacement stands in for characters ToASCII can't transliterate
+	ASCIIReplacement string
+	// ASCIIReport outputs the characters ToASCII couldn't transliterate
+	ASCIIReport bool
+	// Locale selects the character classes and case mappings, eg: tr_TR
+	Locale string
+	// InputEncoding is the encoding the input is decoded from, eg: latin1
+	InputEncoding string
+	// OutputEncoding is the encoding the output is encoded into
+	OutputEncoding string
+	// Unencodable selects what becomes of the characters OutputEncoding
+	// can't encode: error, replace or skip
+	Unencodable string
+	// Charmap is the file of a charmap the input is decoded from
+	Charmap string
+	// Escape converts the input into the escape sequences of a scheme, eg:
+	// json, ahead of the operation
+	Escape string
+	// Unescape converts the escape sequences of a scheme in the output back
+	// into characters
+	Unescape string
+	// ShowNonPrinting makes the bytes of the output visible, in the caret
+	// (cat -v) or hex style
+	ShowNonPrinting string
+	// IgnoreCase matches SET1 as a string, ignoring case
+	IgnoreCase bool
+	// Word matches SET1 as a string, at word boundaries only
+	Word bool
+	// PreserveCase replaces SET1 as a string with SET2 in the case pattern
+	// of the occurrence, eg: Foo to Bar and FOO to BAR
+	PreserveCase bool
+	// Max caps the matches translated, deleted or replaced, in total or on
+	// every line with PerLine
+	Max int
+	// PerLine applies Max to every line
+	PerLine bool
+	// Occurrence only acts on the match of every line at that index,
+	// counting from 1
+	Occurrence int
+	// Regex is a regular expression whose matches are replaced with
+	// Replace, ahead of the operation and on the same lines, scopes and
+	// fields
+	Regex string
+	// Replace is the template replacing the matches of Regex, where $1 or
+	// ${name} stand for capture groups
+	Replace string
+	// Script is a script file of operations run in turn ahead of the
+	// operation, see Script
+	Script string
+	// DumpScript prints the operations of Script as compiled, rather than
+	// running them
+	DumpScript bool
+	// Stages are registered operations run in turn ahead of the operation,
+	// each written like a line of a script, eg: replace foo bar
+	Stages []string
+	// Plugins are external commands run in turn ahead of the operation,
+	// see Plugin
+	Plugins []string
+	// PluginTimeout is how long a plugin has to answer, see Plugin
+	PluginTimeout time.Duration
+	// Mask masks the personal and secret data of the output found by these
+	// detectors, see NewMasker
+	Mask string
 }
 
 // Churn processes the RawString in r,
@@ -71,6 +214,10 @@ func (r *R) Churn(ctx context.Context) {
 	if string(r.RawBytes) == "" {
 		r.RawBytes = []byte(r.RawString)
 	}
+	if r.runeEngine() {
+		r.ChurnRunes()
+		return
+	}
 	if r.FlagEnabled {
 		switch r.Flag.Action {
 		case Action_DELETE:
@@ -81,6 +228,14 @@ func (r *R) Churn(ctx context.Context) {
 			return
 		}
 	}
+	if r.Flag.substitutes() {
+		r.ReplaceSlice()
+		return
+	}
+	if r.escaped() {
+		r.TranslateBytes()
+		return
+	}
 	if len(r.From) > 1 {
 		if (bytes.Contains(r.From, []byte("-")) && len(r.From) == 3) || (bytes.Contains(r.From, []byte(":")) && len(bytes.Split(r.From,
 			[]byte(":"))) == 3) {
@@ -100,16 +255,98 @@ func (r *R) Churn(ctx context.Context) {
 	}
 }
 
+// ChurnRunes processes RawBytes the way Churn does, but character by
+// character rather than byte by byte, as needed by sets holding Unicode
+// classes or characters outside of ASCII.
+func (r *R) ChurnRunes() {
+	if r.runeOp == nil {
+		var err error
+		if r.runeOp, err = r.compileRunes(); err != nil {
+			log.Printf("error occured: %s\n", err.Error())
+			return
+		}
+	}
+	r.RawBytes = r.runeOp.apply(r.RawBytes, r.Stats, r.limit, r.squeeze,
+		r.marks)
+	r.DestString = string(r.RawBytes)
+}
+
+// operates reports whether an operation is configured on r at all.
+func (r *R) operates() bool {
+	return r.FlagEnabled || len(r.From) > 0
+}
+
+// Apply runs the operation configured on r over b and returns the result.
+// r itself is left untouched, so Apply can be called repeatedly on successive
+// chunks of the input.
+func (r *R) Apply(ctx context.Context, b []byte) []byte {
+	if len(b) == 0 || !r.operates() {
+		return b
+	}
+	c := R{
+		RawString:   string(b),
+		RawBytes:    append([]byte(nil), b...),
+		From:        r.From,
+		To:          r.To,
+		FlagEnabled: r.FlagEnabled,
+		Flag:        r.Flag,
+		Stats:       r.Stats,
+		compiled:    r.compiled,
+		loc:         r.loc,
+		runes:       r.runes,
+		runeOp:      r.runeOp,
+		matcher:     r.matcher,
+		limit:       r.limit,
+		squeeze:     r.squeeze,
+		marks:       r.marks,
+	}
+	c.Churn(ctx)
+	return []byte(c.DestString)
+}
+
+// escaped reports whether SET1 or SET2 holds escapes.
+func (r *R) escaped() bool {
+	from, _ := specEscapes(string(r.From))
+	to, _ := specEscapes(string(r.To))
+	return from || to
+}
+
+// TranslateBytes translates the bytes of SET1 into those of SET2 at the same
+// index, SET2 being padded with its last byte like tr does. Both sets are
+// parsed with escapes standing for bytes, see parseByteSpec.
+func (r *R) TranslateBytes() {
+	from, err := parseByteSpec(string(r.From))
+	if err != nil {
+		log.Printf("error parsing search string: %s\n", err.Error())
+		return
+	}
+	to, err := parseByteSpec(string(r.To))
+	if err != nil || len(to) == 0 {
+		log.Printf("error parsing replace string: %q\n", r.To)
+		return
+	}
+	for len(to) < len(from) {
+		to = append(to, to[len(to)-1])
+	}
+	NewByteMap(from, to).Map(r.RawBytes, r.Stats, r.limit, r.marks)
+	r.DestString = string(r.RawBytes)
+}
+
 // Replace replaces the portion of the input slice RawBytes that matches the search
 // bytes From with the replace bytes To in-place.
 // If a byte in RawBytes matches the first byte of From,
 // it replaces that byte with To (considering To as a whole slice).
 // DestString is updated with the new value of RawBytes.
 func (r *R) Replace() {
+	// shift is how far the replacements so far moved the input along
+	shift := 0
 	for i := 0; i < len(r.RawBytes); {
-		if r.RawBytes[i] == r.From[0] {
+		if r.RawBytes[i] == r.From[0] && r.limit.next() {
+			r.Stats.translated(charKey(r.From[0]))
+			r.marks.add(i-shift, r.From[:1])
 			r.RawBytes = append(r.RawBytes[:i], append(r.To, r.RawBytes[i+1:]...)...)
 			i += len(r.To)
+			shift += len(r.To) - 1
 		} else {
 			i++
 		}
@@ -119,16 +356,31 @@ func (r *R) Replace() {
 
 // ReplaceSlice is used when the From length is more than 1. ReplaceSlice replaces the portion of/the
 // input/slice RawBytes/that matches the search slice bytes From with the replace bytes To in-place.
+// With --ignore-case, --word and --preserve-case, it's used whatever the length of From, and the
+// matching and replacement go by them.
 func (r *R) ReplaceSlice() {
+	m := r.matcher
+	if m == nil {
+		var err error
+		if m, err = r.Flag.matcher(r.From); err != nil {
+			log.Printf("error matching search string: %s\n", err.Error())
+			return
+		}
+	}
 	// Preallocate a buffer to avoid frequent reallocations
 	buffer := make([]byte, 0, len(r.RawBytes)) // Initial capacity can be tuned based on expected final size
 
 	i := 0
 	for i < len(r.RawBytes) {
-		if i+len(r.From) <= len(r.RawBytes) && ByteSliceEqual(r.RawBytes[i:i+len(r.From)],
-			r.From) {
-			buffer = append(buffer, r.To...)
-			i += len(r.From)
+		if n := m.match(r.RawBytes, i); n > 0 {
+			if r.limit.next() {
+				r.Stats.translated(string(r.From))
+				r.marks.add(i, r.RawBytes[i:i+n])
+				buffer = append(buffer, m.replacement(r.RawBytes[i:i+n], r.To)...)
+			} else {
+				buffer = append(buffer, r.RawBytes[i:i+n]...)
+			}
+			i += n
 		} else {
 			buffer = append(buffer, r.RawBytes[i])
 			i++
@@ -243,23 +495,7 @@ func (r *R) RangeMutate(ctxFunc context.CancelFunc) int {
 		ctxFunc()
 		return 1
 	}
-	for i := 0; i < len(r.RawBytes); i++ {
-		go func(i int) {
-			for j := 0; j < len(r.From); j++ {
-				if r.RawBytes[i] == r.From[j] {
-					if j > len(r.To) {
-						r.Lock()
-						r.RawBytes[i] = r.To[len(r.To)-1]
-						r.Unlock()
-					} else {
-						r.Lock()
-						r.RawBytes[i] = r.To[j]
-						r.Unlock()
-					}
-				}
-			}
-		}(i)
-	}
+	NewByteMap(r.From, r.To).Map(r.RawBytes, r.Stats, r.limit, r.marks)
 	return 0
 }
 
@@ -267,23 +503,31 @@ func (r *R) RangeMutate(ctxFunc context.CancelFunc) int {
 // Potentially be removed.
 func resolveRange(b []byte) ([]byte, error) {
 	var rangee = []byte{}
+	if !isRange(b) {
+		return []byte(""), fmt.Errorf("err: could not process byte, "+
+			"not in right format: %s\n", b)
+	}
 	for i := 0; i < len(b); i += 3 {
-		if len(b) != 3 && b[1] != []byte("-")[0] {
-			return []byte(""), fmt.Errorf("err: could not process byte, "+
-				"not in right format: %s\n", b)
-		}
-		asciiDiff := rune(b[2]) - rune(b[0])
-		in := rune(b[0])
-		rangee = append(rangee, []byte(string(in))...)
-		in = in + 1
-		for i := 0; i < int(asciiDiff); i++ {
-			rangee = append(rangee, []byte(string(in))...)
-			in = in + 1
+		for in := int(b[i]); in <= int(b[i+2]); in++ {
+			rangee = append(rangee, byte(in))
 		}
 	}
 	return rangee, nil
 }
 
+// isRange reports whether b is made up of ranges only, eg: A-Za-z0-9
+func isRange(b []byte) bool {
+	if len(b) == 0 || len(b)%3 != 0 {
+		return false
+	}
+	for i := 0; i < len(b); i += 3 {
+		if b[i+1] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
 // valRegexRange handles general regex syntax errors: parses regex expressions,
 // a byte of length n*3, where n has the length of 3,
 // and that the ascii value of the maxRange is greater than that of the minRange
@@ -328,10 +572,6 @@ func ByteSliceEqual(a, b []byte) bool {
 // options. It returns 0 if successful,
 // and >0 if an error was encountered along the way
 func (r *R) ResolveRegexArg() int {
-	fmt.Printf("from: %v %d, to: %v %d\n", PosixBracRegexMap[string(r.From)], len(bytes.Split(r.From,
-		[]byte(":"))),
-		PosixBracRegexMap[string(r.To)], len(bytes.Split(r.To,
-			[]byte(":"))))
 	var errNo = 0
 	if bytes.Contains(r.From, []byte(":")) && len(bytes.Split(r.From,
 		[]byte(":"))) == 3 {
@@ -375,6 +615,13 @@ func (r *R) Delete(ctx context.Context) {
 		}
 
 		r.DeleteRange(ctx)
+	} else if escaped, _ := specEscapes(r.Flag.DelString); escaped {
+		var err error
+		if r.From, err = parseByteSpec(r.Flag.DelString); err != nil {
+			log.Printf("error parsing delete string: %s\n", err.Error())
+			return
+		}
+		r.DeleteOne(ctx)
 	} else {
 		r.From = []byte(r.Flag.DelString)
 		r.DeleteOne(ctx)
@@ -399,18 +646,14 @@ func (r *R) Delete(ctx context.Context) {
 // as defined by the range expression.
 func (r *R) DeleteOne(ctx context.Context) {
 	// Preallocate a buffer to avoid frequent reallocations
-	var buffer []byte
-	delString := r.From
+	buffer := make([]byte, 0, len(r.RawBytes))
+	set := NewByteSet(r.From)
 	for i := 0; i < len(r.RawBytes); i++ {
-		shouldDelete := false
-		for j := 0; j < len(delString); j++ {
-			if r.RawBytes[i] == delString[j] {
-				shouldDelete = true
-				break
-			}
-		}
-		if !shouldDelete {
+		if !set.Has(r.RawBytes[i]) || !r.limit.next() {
 			buffer = append(buffer, r.RawBytes[i])
+		} else {
+			r.Stats.deleted(charKey(r.RawBytes[i]))
+			r.marks.add(i, r.RawBytes[i:i+1])
 		}
 	}
 	r.RawBytes = buffer
@@ -420,50 +663,28 @@ func (r *R) DeleteOne(ctx context.Context) {
 // input text.
 func (r *R) Squeeze(ctx context.Context) {
 	// Preallocate a buffer to avoid frequent reallocations
-	var buffer []byte
-	n := 1
-	for i := 0; i < len(r.RawBytes); i += n {
-		fmt.Println("squeeze: outer loop")
-		fmt.Println("squeeze: processing", string(r.RawBytes[i:]))
-		for j := 0; j < len(r.Flag.SqueezeBytes); j++ {
-			fmt.Println("squeeze: inner loop")
-			if r.RawBytes[i] == r.Flag.SqueezeBytes[j] {
-				fmt.Println("found repeated")
-				fmt.Println(string(r.RawBytes[i]))
-				fmt.Printf("first %v, second %v\n", r.RawBytes[i],
-					r.RawBytes[i+1])
-				if r.RawBytes[i] == r.RawBytes[i+1] {
-					fmt.Println("enter squeeze rep loop")
-					num := endRepeated(r.RawBytes[i:])
-					buffer = append(buffer, r.RawBytes[i])
-					n += num - 1
-					fmt.Println("skipping ahead by: ", num)
-				}
-				break
-			} else {
-				buffer = append(buffer, r.RawBytes[i])
-				n += 1
-				fmt.Println("incrementing instead")
-			}
+	buffer := make([]byte, 0, len(r.RawBytes))
+	spec := r.Flag.SqueezeBytes
+	if escaped, _ := specEscapes(string(spec)); escaped {
+		var err error
+		if spec, err = parseByteSpec(string(spec)); err != nil {
+			log.Printf("error parsing squeeze string: %s\n", err.Error())
+			return
 		}
-
 	}
-	r.RawBytes = buffer
-	r.DestString = string(r.RawBytes)
-}
-
-// endRepeated checks how many indices from byt's first value has repeated
-// values.
-func endRepeated(byt []byte) int {
-	fmt.Printf("end repeated: %v \n", string(byt))
-	cons := byt[0]
-	for i := 1; i < len(byt); i++ {
-		if cons == byt[i] {
-			i++
-		} else {
-			return i
+	set := NewByteSet(spec)
+	prev, run := r.squeeze.load()
+	for i, c := range r.RawBytes {
+		if rune(c) == prev && set.Has(c) {
+			r.Stats.squeezed(charKey(c), !run)
+			r.marks.add(i, r.RawBytes[i:i+1])
+			run = true
+			continue
 		}
+		buffer = append(buffer, c)
+		prev, run = rune(c), false
 	}
-	// unlikely
-	return 1
+	r.squeeze.save(prev, run)
+	r.RawBytes = buffer
+	r.DestString = string(r.RawBytes)
 }
@@ -0,0 +1,64 @@
+package r
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// NewRegexReplacer returns a Stage replacing the matches of the regular
+// expression pattern, line by line, with template. Within template, $1 or
+// ${name} stand for the text of the matching capture group, as in
+// regexp.Regexp.Expand. The matches are counted into stats, which may be
+// nil, under pattern.
+func NewRegexReplacer(pattern, template string, stats *Stats) (Stage, error) {
+	fn, err := regexReplacer(pattern, template, stats)
+	if err != nil {
+		return nil, err
+	}
+	return &lineStage{fn: fn}, nil
+}
+
+// regexReplacer returns the function NewRegexReplacer runs over every line,
+// appending the result to dst.
+func regexReplacer(pattern, template string,
+	stats *Stats) (func(dst, line []byte) []byte, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("err: invalid regex %q: %w", pattern, err)
+	}
+	return func(dst, line []byte) []byte {
+		last := 0
+		for _, m := range re.FindAllSubmatchIndex(line, -1) {
+			// matches are counted by pattern, as the text matched is
+			// unbounded
+			stats.translated(pattern)
+			dst = append(dst, line[last:m[0]]...)
+			dst = re.Expand(dst, []byte(template), line, m)
+			last = m[1]
+		}
+		return append(dst, line[last:]...)
+	}, nil
+}
+
+// regex returns the replacement of the Regex and Replace flags, or nil.
+// Without a template, the matches are deleted. It runs ahead of the
+// operation on every part of the input the operation is applied to, the
+// line terminator left out.
+func (f *Flags) regex(stats *Stats) (func(b []byte) []byte, error) {
+	switch {
+	case f == nil:
+		return nil, nil
+	case f.Regex == "" && f.Replace != "":
+		return nil, fmt.Errorf("err: --replace needs --regex")
+	case f.Regex == "":
+		return nil, nil
+	}
+	fn, err := regexReplacer(f.Regex, f.Replace, stats)
+	if err != nil {
+		return nil, err
+	}
+	return func(b []byte) []byte {
+		line, eol := splitEOL(b)
+		return append(fn(nil, line), eol...)
+	}, nil
+}
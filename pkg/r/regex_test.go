@@ -0,0 +1,99 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestRegexReplacer(t *testing.T) {
+	test := []struct {
+		Pattern, Template string
+		Raw, Expected     string
+	}{
+		{`(\w+)@(\w+)`, "$2 at $1", "bob@home\nann@work\n",
+			"home at bob\nwork at ann\n"},
+		{`(?P<year>\d{4})-(?P<month>\d\d)`, "${month}/${year}",
+			"2024-05 and 1999-12", "05/2024 and 12/1999"},
+		{`\s+$`, "", "trailing  \nnone\n\t\n", "trailing\nnone\n\n"},
+		{`^`, "> ", "a\nb\n", "> a\n> b\n"},
+		{`x`, "$$", "axa", "a$a"},
+	}
+	for i := 0; i < len(test); i++ {
+		for _, size := range []int{1, 3, 1024} {
+			s, err := NewRegexReplacer(test[i].Pattern, test[i].Template, nil)
+			if err != nil {
+				t.Errorf("%d: unexpected error: %s", i, err)
+				break
+			}
+			got := runStage(s, []byte(test[i].Raw), size)
+			if string(got) != test[i].Expected {
+				t.Errorf("%d/%d: expected %q. got %q\n", i, size,
+					test[i].Expected, got)
+			}
+		}
+	}
+}
+
+func TestStreamRegex(t *testing.T) {
+	test := []struct {
+		R        R
+		Flags    Flags
+		Raw      string
+		Expected string
+	}{
+		{R{}, Flags{Regex: `[0-9]+`, Replace: "<$0>"}, "a1b22\n",
+			"a<1>b<22>\n"},
+		{R{}, Flags{Regex: `\s*#.*`}, "x = 1 # one\n# none\n", "x = 1\n\n"},
+		// the operation runs on the result of the replacement
+		{R{From: []byte("a-z"), To: []byte("A-Z")},
+			Flags{Regex: `(\w+)=(\w+)`, Replace: "$2=$1"}, "key=value\n",
+			"VALUE=KEY\n"},
+		{R{FlagEnabled: true}, Flags{Action: Action_DELETE, DelString: "-",
+			Regex: `(\d+)/(\d+)`, Replace: "$2-$1"}, "3/4\n", "43\n"},
+		{R{FlagEnabled: true}, Flags{Action: Action_SQUEEZE,
+			SqueezeBytes: []byte(" "), Regex: `,`, Replace: " "}, "a, b,c\n",
+			"a b c\n"},
+		// only the lines, scopes and fields selected are replaced
+		{R{}, Flags{Regex: `\d`, Replace: "#", Lines: "2"}, "a1\nb2\nc3\n",
+			"a1\nb#\nc3\n"},
+		{R{}, Flags{Regex: `\d`, Replace: "#", Within: `\[[^]]*\]`},
+			"1 [2 3] 4\n", "1 [# #] 4\n"},
+		{R{}, Flags{Regex: `^\w`, Fields: "2", CSV: true}, "ab,cd,ef\n",
+			"ab,d,ef\n"},
+		{R{}, Flags{Regex: `\s+$`, JSONL: true}, `{"a": "x  ", "b": 1}` + "\n",
+			`{"a": "x", "b": 1}` + "\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &test[i].R
+		r.Flag = &test[i].Flags
+		out := &bytes.Buffer{}
+		err := r.Stream(context.Background(), bytes.NewBufferString(test[i].Raw),
+			out)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if out.String() != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, out)
+		}
+	}
+}
+
+func TestRegexFlags(t *testing.T) {
+	for _, f := range []Flags{
+		{Replace: "x"},
+		{Regex: "(", Replace: "x"},
+	} {
+		if _, err := f.regex(nil); err == nil {
+			t.Errorf("%+v: expected error\n", f)
+		}
+	}
+	stats := NewStats()
+	s, _ := NewRegexReplacer("a+", "b", stats)
+	runStage(s, []byte("aa a aa"), 1024)
+	// the matches are counted by pattern
+	if stats.Translated["a+"] != 3 || len(stats.Translated) != 1 {
+		t.Errorf("unexpected stats: %v\n", stats.Translated)
+	}
+}
@@ -0,0 +1,276 @@
+package r
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation is a Stage along with the name it was registered under. It
+// holds whatever streaming state it needs between calls to Process, and
+// returns what's left of it from Flush.
+type Operation interface {
+	Stage
+	Name() string
+}
+
+// Env is what the operations of a pipeline are built with.
+type Env struct {
+	// Locale is the locale of character classes and case mappings
+	Locale *Locale
+	// Stats collects statistics, if not nil
+	Stats *Stats
+	// PluginTimeout is how long a plugin has to answer, see Plugin
+	PluginTimeout time.Duration
+}
+
+// Registration describes an operation to Register, making it available to
+// script files (-f) and --stage under Name.
+type Registration struct {
+	Name string
+	// MinArgs and MaxArgs bound the number of arguments the operation takes
+	MinArgs, MaxArgs int
+	// Usage shows how the operation is written, eg: translate SET1 SET2
+	Usage string
+	// New builds the Stage of the operation from its arguments
+	New func(args []string, env *Env) (Stage, error)
+	// Defaults are the values of the arguments past MinArgs when left out,
+	// so that New is handed them
+	Defaults []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Registration{}
+)
+
+// Register makes an operation available under reg.Name. It's meant to be
+// called from the init function of the package providing the operation,
+// eg:
+//
+//	func init() {
+//		r.Register(r.Registration{Name: "rot13", Usage: "rot13",
+//			New: func(args []string, env *r.Env) (r.Stage, error) {
+//				return &rot13{}, nil
+//			}})
+//	}
+//
+// Register panics if the registration is incomplete or reg.Name is taken.
+func Register(reg Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if reg.Name == "" || reg.New == nil || reg.MaxArgs < reg.MinArgs {
+		panic(fmt.Sprintf("r: incomplete registration of operation %q",
+			reg.Name))
+	}
+	if _, ok := registry[reg.Name]; ok {
+		panic(fmt.Sprintf("r: operation %q registered twice", reg.Name))
+	}
+	if reg.Usage == "" {
+		reg.Usage = reg.Name
+	}
+	registry[reg.Name] = reg
+}
+
+// Lookup returns the operation registered under name.
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	reg, ok := registry[name]
+	return reg, ok
+}
+
+// Operations returns the names of the registered operations, in
+// alphabetical order.
+func Operations() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupOperation returns the operation registered under name, checking
+// that it takes that many arguments.
+func lookupOperation(name string, args int) (Registration, error) {
+	reg, ok := Lookup(name)
+	if !ok {
+		return reg, fmt.Errorf("err: unknown operation %q. expecting one "+
+			"of: %s", name, strings.Join(Operations(), ", "))
+	}
+	if args < reg.MinArgs || args > reg.MaxArgs {
+		return reg, fmt.Errorf("err: expecting %s", reg.Usage)
+	}
+	return reg, nil
+}
+
+// Build builds the operation registered under name from its arguments. env
+// may be nil, and its Locale defaults to CLocale.
+func Build(name string, args []string, env *Env) (Operation, error) {
+	reg, err := lookupOperation(name, len(args))
+	if err != nil {
+		return nil, err
+	}
+	e := Env{Locale: CLocale}
+	if env != nil {
+		e = *env
+	}
+	if e.Locale == nil {
+		e.Locale = CLocale
+	}
+	s, err := reg.New(reg.withDefaults(args), &e)
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := s.(Operation); ok && op.Name() == name {
+		return op, nil
+	}
+	return &namedStage{Stage: s, name: name}, nil
+}
+
+// namedStage gives a Stage the name of the operation it was built for.
+type namedStage struct {
+	Stage
+	name string
+}
+
+// Name implements Operation.
+func (s *namedStage) Name() string {
+	return s.name
+}
+
+// Err returns the error that stopped the stage, if it can fail.
+func (s *namedStage) Err() error {
+	if f, ok := s.Stage.(failer); ok {
+		return f.Err()
+	}
+	return nil
+}
+
+// withDefaults returns args, along with the defaults of the arguments left
+// out.
+func (reg Registration) withDefaults(args []string) []string {
+	for i := len(args) - reg.MinArgs; i >= 0 && i < len(reg.Defaults); i++ {
+		args = append(args[:len(args):len(args)], reg.Defaults[i])
+	}
+	return args
+}
+
+// newOpStage returns a Stage running the operation configured on r line by
+// line, the way Stream does. Runs of repeats are squeezed across lines.
+func newOpStage(r *R) (Stage, error) {
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	r.squeeze = newSqueezeRun()
+	return &lineStage{eol: true, fn: func(dst, line []byte) []byte {
+		return append(dst, r.Apply(context.Background(), line)...)
+	}}, nil
+}
+
+// the operations built in
+func init() {
+	for _, reg := range []Registration{
+		{"translate", 2, 2, "translate SET1 SET2", func(args []string,
+			env *Env) (Stage, error) {
+			return newOpStage(&R{From: []byte(args[0]), To: []byte(args[1]),
+				Flag: &Flags{Locale: env.Locale.Name}, Stats: env.Stats})
+		}, nil},
+		{"delete", 1, 1, "delete SET", func(args []string,
+			env *Env) (Stage, error) {
+			return newOpStage(&R{FlagEnabled: true, Flag: &Flags{
+				Action: Action_DELETE, DelString: args[0],
+				Locale: env.Locale.Name}, Stats: env.Stats})
+		}, nil},
+		{"squeeze", 1, 1, "squeeze SET", func(args []string,
+			env *Env) (Stage, error) {
+			return newOpStage(&R{FlagEnabled: true, Flag: &Flags{
+				Action: Action_SQUEEZE, SqueezeString: args[0],
+				SqueezeBytes: []byte(args[0]), Locale: env.Locale.Name},
+				Stats: env.Stats})
+		}, nil},
+		{"replace", 2, 2, "replace FROM TO", func(args []string,
+			env *Env) (Stage, error) {
+			if args[0] == "" {
+				return nil, fmt.Errorf("err: nothing to replace")
+			}
+			return NewRegexReplacer(regexp.QuoteMeta(args[0]),
+				strings.ReplaceAll(args[1], "$", "$$"), env.Stats)
+		}, nil},
+		{"regex", 1, 2, "regex PATTERN [TEMPLATE]", func(args []string,
+			env *Env) (Stage, error) {
+			return NewRegexReplacer(args[0], args[1], env.Stats)
+		}, []string{""}},
+		{"eol", 1, 1, "eol lf|crlf|cr|auto", func(args []string,
+			env *Env) (Stage, error) {
+			return ParseEOL(args[0])
+		}, nil},
+		{"case", 1, 1, "case upper|lower|title", func(args []string,
+			env *Env) (Stage, error) {
+			return NewCaser(args[0], env.Locale)
+		}, nil},
+		{"fold", 0, 0, "fold", func(args []string,
+			env *Env) (Stage, error) {
+			return NewFolder(env.Locale), nil
+		}, nil},
+		{"normalize", 1, 1, "normalize nfc|nfd|nfkc|nfkd", func(args []string,
+			env *Env) (Stage, error) {
+			return NewNormalizer(args[0])
+		}, nil},
+		{"sanitize", 0, 1, "sanitize [OPTIONS]", func(args []string,
+			env *Env) (Stage, error) {
+			return ParseSanitizer(args[0])
+		}, []string{"ansi,controls"}},
+		{"escape", 1, 1, "escape c|json|url|html|shell", func(args []string,
+			env *Env) (Stage, error) {
+			return NewEscaper(args[0], env.Stats)
+		}, nil},
+		{"unescape", 1, 1, "unescape c|json|url|html|shell",
+			func(args []string, env *Env) (Stage, error) {
+				return NewUnescaper(args[0])
+			}, nil},
+		{"to-ascii", 0, 1, "to-ascii [REPLACEMENT]", func(args []string,
+			env *Env) (Stage, error) {
+			return &Transliterator{Replacement: args[0], Stats: env.Stats}, nil
+		}, []string{"?"}},
+	} {
+		Register(reg)
+	}
+}
+
+// stageScript returns the operations given by --stage, each written like a
+// line of a script, as a Script.
+func (f *Flags) stageScript() (*Script, error) {
+	s := &Script{}
+	for i, spec := range f.Stages {
+		words, err := splitScriptLine(spec)
+		if err != nil {
+			return nil, fmt.Errorf("--stage %d: %w", i+1, err)
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("err: --stage needs an operation")
+		}
+		if _, err = lookupOperation(words[0], len(words)-1); err != nil {
+			return nil, fmt.Errorf("--stage %d: %w", i+1, err)
+		}
+		s.Ops = append(s.Ops, ScriptOp{Line: i + 1, Name: words[0],
+			Args: words[1:], Flag: "--stage"})
+	}
+	return s, nil
+}
+
+// stageOps returns the pipeline of the operations given by --stage, or nil.
+func (f *Flags) stageOps(env *Env) (Pipeline, error) {
+	s, err := f.stageScript()
+	if err != nil || len(s.Ops) == 0 {
+		return nil, err
+	}
+	return s.Compile(env)
+}
@@ -0,0 +1,241 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// rot13 is an Operation as a third party would register it.
+type rot13 struct{}
+
+func (rot13) Name() string { return "rot13" }
+
+func (rot13) Process(b []byte) []byte {
+	return bytes.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z':
+			return 'a' + (c-'a'+13)%26
+		case c >= 'A' && c <= 'Z':
+			return 'A' + (c-'A'+13)%26
+		}
+		return c
+	}, b)
+}
+
+func (rot13) Flush() []byte { return nil }
+
+// failing is a Stage that fails once it has seen its argument.
+type failing struct {
+	on  string
+	err error
+}
+
+func (s *failing) Process(b []byte) []byte {
+	if bytes.Contains(b, []byte(s.on)) {
+		s.err = errors.New("err: met " + s.on)
+	}
+	return b
+}
+
+func (s *failing) Flush() []byte { return nil }
+
+func (s *failing) Err() error { return s.err }
+
+// builds counts the stages built for the count-builds operation.
+var builds int
+
+func init() {
+	Register(Registration{Name: "rot13",
+		New: func(args []string, env *Env) (Stage, error) {
+			return rot13{}, nil
+		}})
+	Register(Registration{Name: "fail-on", MinArgs: 1, MaxArgs: 1,
+		Usage: "fail-on STRING",
+		New: func(args []string, env *Env) (Stage, error) {
+			return &failing{on: args[0]}, nil
+		}})
+	Register(Registration{Name: "count-builds",
+		New: func(args []string, env *Env) (Stage, error) {
+			builds++
+			return rot13{}, nil
+		}})
+}
+
+func TestRegister(t *testing.T) {
+	for i, reg := range []Registration{
+		{Name: "translate", MinArgs: 2, MaxArgs: 2,
+			New: func([]string, *Env) (Stage, error) { return nil, nil }},
+		{Name: "", New: func([]string, *Env) (Stage, error) { return nil, nil }},
+		{Name: "no-new"},
+		{Name: "bad-args", MinArgs: 2, MaxArgs: 1,
+			New: func([]string, *Env) (Stage, error) { return nil, nil }},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d: expected panic\n", i)
+				}
+			}()
+			Register(reg)
+		}()
+	}
+	names := Operations()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("expected sorted names. got %q\n", names)
+	}
+	for _, name := range []string{"translate", "delete", "squeeze", "rot13"} {
+		if _, ok := Lookup(name); !ok {
+			t.Errorf("expected %q to be registered\n", name)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	test := []struct {
+		Name     string
+		Args     []string
+		Expected string
+	}{
+		{"frob", nil, "unknown operation"},
+		{"translate", []string{"a"}, "expecting translate SET1 SET2"},
+		{"rot13", []string{"x"}, "expecting rot13"},
+		{"case", []string{"shouting"}, "shouting"},
+	}
+	for i := 0; i < len(test); i++ {
+		_, err := Build(test[i].Name, test[i].Args, nil)
+		if err == nil || !strings.Contains(err.Error(), test[i].Expected) {
+			t.Errorf("%d: expected error %q. got %v\n", i, test[i].Expected,
+				err)
+		}
+	}
+	for _, name := range []string{"rot13", "fold", "fail-on"} {
+		args := []string{}
+		if name == "fail-on" {
+			args = append(args, "x")
+		}
+		op, err := Build(name, args, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if op.Name() != name {
+			t.Errorf("expected %q. got %q\n", name, op.Name())
+		}
+	}
+}
+
+func TestStandalone(t *testing.T) {
+	test := []struct {
+		Flags      Flags
+		Standalone bool
+	}{
+		{Flags{}, false},
+		{Flags{Locale: "tr_TR", Max: 3}, false},
+		{Flags{Stages: []string{"count-builds"}}, true},
+		{Flags{Plugins: []string{"no-such-plugin-anywhere"}}, true},
+		{Flags{Mask: MaskAll}, true},
+		// errors are left for the stream to report
+		{Flags{Stages: []string{"frob"}}, true},
+		{Flags{Stages: []string{"translate a"}}, true},
+	}
+	for i := 0; i < len(test); i++ {
+		if got := test[i].Flags.Standalone(); got != test[i].Standalone {
+			t.Errorf("%d: expected %v. got %v\n", i, test[i].Standalone, got)
+		}
+	}
+	if builds != 0 {
+		t.Errorf("expected no stage built. got %d\n", builds)
+	}
+}
+
+func TestStandaloneStageFlags(t *testing.T) {
+	// every flag configuring stages, set alone
+	test := []Flags{
+		{Charmap: "cp.map"}, {InputEncoding: "latin1"}, {Sanitize: "all"},
+		{Normalize: "nfc"}, {Fold: true}, {Escape: "c"}, {Script: "s.tr"},
+		{Stages: []string{"rot13"}}, {Plugins: []string{"cat"}},
+		{Unescape: "c"}, {Case: "upper"}, {ToASCII: true},
+		{NormalizeOutput: "nfc"}, {Mask: MaskAll}, {EOL: "lf"},
+		{OutputEncoding: "latin1"}, {ShowNonPrinting: "caret"},
+	}
+	if n := len((&Flags{}).stageFlags(nil, nil)); n != len(test) {
+		t.Fatalf("expected %d stage flags. got %d\n", len(test), n)
+	}
+	seen := map[int]bool{}
+	for i := 0; i < len(test); i++ {
+		if !test[i].Standalone() {
+			t.Errorf("%d: expected standalone\n", i)
+		}
+		for j, s := range test[i].stageFlags(nil, nil) {
+			if s.set {
+				seen[j] = true
+			}
+		}
+	}
+	if len(seen) != len(test) {
+		t.Errorf("expected every stage flag set once. got %d\n", len(seen))
+	}
+}
+
+func TestStreamStages(t *testing.T) {
+	test := []struct {
+		R        R
+		Flags    Flags
+		Script   string
+		Raw      string
+		Expected string
+	}{
+		{R{}, Flags{Stages: []string{"rot13"}}, "", "Hello\n", "Uryyb\n"},
+		{R{}, Flags{Stages: []string{"replace 'a b' c", "case upper"}}, "",
+			"xa by\n", "XCY\n"},
+		// --stage runs after the script, and both ahead of the operation
+		{R{From: []byte("a-z"), To: []byte("A-Z")},
+			Flags{Stages: []string{"replace b x"}}, "rot13\n", "o\n", "X\n"},
+	}
+	dir := t.TempDir()
+	for i := 0; i < len(test); i++ {
+		if test[i].Script != "" {
+			path := filepath.Join(dir, "s.tr")
+			err := os.WriteFile(path, []byte(test[i].Script), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test[i].Flags.Script = path
+		}
+		r := &test[i].R
+		r.Flag = &test[i].Flags
+		out := &bytes.Buffer{}
+		err := r.Stream(context.Background(), bytes.NewBufferString(test[i].Raw),
+			out)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if out.String() != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, out)
+		}
+	}
+}
+
+func TestStreamStageErrors(t *testing.T) {
+	for i, f := range []Flags{
+		{Stages: []string{"frob"}},
+		{Stages: []string{""}},
+		{Stages: []string{"replace 'a"}},
+		// a registered stage failing stops the stream
+		{Stages: []string{"fail-on x"}},
+	} {
+		r := &R{Flag: &f}
+		err := r.Stream(context.Background(), bytes.NewBufferString("abc\nxyz\n"),
+			&bytes.Buffer{})
+		if err == nil {
+			t.Errorf("%d: expected error\n", i)
+		}
+	}
+}
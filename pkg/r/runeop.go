@@ -0,0 +1,210 @@
+package r
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// runeOp is the operation configured on r compiled for the rune engine,
+// which works on characters rather than bytes. It's used whenever a set
+// holds Unicode classes or characters outside of ASCII, or is complemented.
+type runeOp struct {
+	action     int
+	set        *RuneSet
+	complement bool
+	// mapping translates SET1 to SET2. When complemented, everything not in
+	// SET1 translates to last instead.
+	mapping map[rune]rune
+	last    rune
+	// caseMap, if set, translates in place of mapping. It's the case mapping
+	// of the locale for [:upper:] to [:lower:] and the reverse.
+	caseMap func(rune) rune
+}
+
+// locale returns the locale of the operation configured on r, as cached by
+// compile if it ran.
+func (r *R) locale() (*Locale, error) {
+	if r.loc != nil {
+		return r.loc, nil
+	}
+	return r.Flag.locale()
+}
+
+// runeEngine reports whether the operation configured on r runs on the rune
+// engine, as cached by compile if it ran, see runeMode.
+func (r *R) runeEngine() bool {
+	if r.compiled {
+		return r.runes
+	}
+	return r.runeMode()
+}
+
+// runeMode reports whether the operation configured on r needs the rune
+// engine.
+func (r *R) runeMode() bool {
+	loc, err := r.locale()
+	if err != nil {
+		// let compileRunes report the error
+		return true
+	}
+	if r.Flag != nil && r.Flag.Complement {
+		return true
+	}
+	if r.Flag.substitutes() {
+		// substitutions work on strings, not sets
+		return false
+	}
+	specs := r.specs()
+	for _, spec := range specs {
+		if isRuneSpec(spec, loc) {
+			return true
+		}
+	}
+	return escapedRuneSpecs(specs...)
+}
+
+// specs returns the sets of the operation configured on r: SET1 and SET2,
+// or the set deleted or squeezed.
+func (r *R) specs() []string {
+	if r.FlagEnabled {
+		switch r.Flag.Action {
+		case Action_DELETE:
+			return []string{r.Flag.DelString}
+		case Action_SQUEEZE:
+			return []string{r.squeezeSpec()}
+		}
+	}
+	return []string{string(r.From), string(r.To)}
+}
+
+// squeezeSpec returns the set of characters to squeeze.
+func (r *R) squeezeSpec() string {
+	if r.Flag.SqueezeString != "" {
+		return r.Flag.SqueezeString
+	}
+	return string(r.Flag.SqueezeBytes)
+}
+
+// compileRunes compiles the operation configured on r for the rune engine.
+func (r *R) compileRunes() (*runeOp, error) {
+	op := &runeOp{complement: r.Flag != nil && r.Flag.Complement}
+	spec := string(r.From)
+	if r.FlagEnabled {
+		op.action = r.Flag.Action
+		switch op.action {
+		case Action_DELETE:
+			spec = r.Flag.DelString
+		case Action_SQUEEZE:
+			spec = r.squeezeSpec()
+		}
+	}
+	loc, err := r.locale()
+	if err != nil {
+		return nil, err
+	}
+	if op.set, err = loc.ParseRuneSet(spec); err != nil {
+		return nil, err
+	}
+	if op.action != 0 {
+		return op, nil
+	}
+	if !op.complement {
+		switch spec + string(r.To) {
+		case "[:upper:][:lower:]":
+			op.caseMap = loc.ToLower
+			return op, nil
+		case "[:lower:][:upper:]":
+			op.caseMap = loc.ToUpper
+			return op, nil
+		}
+	}
+	to, err := loc.ParseRuneSet(string(r.To))
+	if err != nil {
+		return nil, err
+	}
+	toRunes, err := to.Runes()
+	if err != nil {
+		return nil, err
+	}
+	if len(toRunes) == 0 {
+		return nil, fmt.Errorf("err: SET2 must not be empty")
+	}
+	op.last = toRunes[len(toRunes)-1]
+	if op.complement {
+		return op, nil
+	}
+	fromRunes, err := op.set.Runes()
+	if err != nil {
+		return nil, err
+	}
+	// like tr, a SET2 shorter than SET1 is padded with its last character
+	op.mapping = make(map[rune]rune, len(fromRunes))
+	for i, c := range fromRunes {
+		if _, ok := op.mapping[c]; ok {
+			continue
+		}
+		if i < len(toRunes) {
+			op.mapping[c] = toRunes[i]
+		} else {
+			op.mapping[c] = op.last
+		}
+	}
+	return op, nil
+}
+
+// selects reports whether the operation acts on c.
+func (op *runeOp) selects(c rune) bool {
+	return op.set.Has(c) != op.complement
+}
+
+// apply runs the operation over b, recording what it does into stats and
+// where into marks, both of which may be nil. Only the characters selected
+// by limit, which may be nil, are translated or deleted. Squeezing picks up
+// where squeeze, which may be nil, left off.
+func (op *runeOp) apply(b []byte, stats *Stats, limit *limit,
+	squeeze *squeezeRun, marks *marks) []byte {
+	buffer := make([]byte, 0, len(b))
+	prev, run := squeeze.load()
+	for i := 0; i < len(b); {
+		c, size := utf8.DecodeRune(b[i:])
+		off, raw := i, b[i:i+size]
+		i += size
+		if c == utf8.RuneError && size == 1 {
+			// invalid UTF-8 passes through untouched
+			buffer = append(buffer, raw...)
+			prev, run = -1, false
+			continue
+		}
+		squeezed := false
+		switch {
+		case !op.selects(c):
+			buffer = append(buffer, raw...)
+		case op.action != Action_SQUEEZE && !limit.next():
+			buffer = append(buffer, raw...)
+		case op.action == Action_DELETE:
+			stats.deleted(string(c))
+			marks.add(off, raw)
+		case op.action == Action_SQUEEZE && c == prev:
+			stats.squeezed(string(c), !run)
+			marks.add(off, raw)
+			squeezed = true
+		case op.action == Action_SQUEEZE:
+			buffer = append(buffer, raw...)
+		case op.complement:
+			stats.translated(string(c))
+			marks.add(off, raw)
+			buffer = utf8.AppendRune(buffer, op.last)
+		case op.caseMap != nil:
+			stats.translated(string(c))
+			marks.add(off, raw)
+			buffer = utf8.AppendRune(buffer, op.caseMap(c))
+		default:
+			stats.translated(string(c))
+			marks.add(off, raw)
+			buffer = utf8.AppendRune(buffer, op.mapping[c])
+		}
+		prev, run = c, squeezed
+	}
+	squeeze.save(prev, run)
+	return buffer
+}
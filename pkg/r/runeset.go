@@ -0,0 +1,280 @@
+package r
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// asciiTable is the \p{ASCII} class
+var asciiTable = &unicode.RangeTable{
+	R16:         []unicode.Range16{{Lo: 0, Hi: 0x7f, Stride: 1}},
+	LatinOffset: 1,
+}
+
+// LookupClass resolves the name of a Unicode class into its table. name may
+// be a general category (L, Lu, Nd...), a script (Greek, Han...), a property
+// (White_Space...), or ASCII.
+func LookupClass(name string) (*unicode.RangeTable, bool) {
+	if name == "ASCII" {
+		return asciiTable, true
+	}
+	for _, tables := range []map[string]*unicode.RangeTable{
+		unicode.Categories, unicode.Scripts, unicode.Properties} {
+		if t, ok := tables[name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// setItem is a single element of a RuneSet: either the range of runes Lo to
+// Hi, or the members of a Unicode class.
+type setItem struct {
+	Lo, Hi  rune
+	Table   *unicode.RangeTable
+	Negated bool
+}
+
+func (it setItem) has(c rune) bool {
+	if it.Table == nil {
+		return c >= it.Lo && c <= it.Hi
+	}
+	return unicode.Is(it.Table, c) != it.Negated
+}
+
+// RuneSet is a set of runes as written in SET1 or SET2, made up of literal
+// characters, ranges, POSIX classes and Unicode classes.
+type RuneSet struct {
+	items []setItem
+}
+
+// ParseRuneSet parses spec into a RuneSet, in the C locale. On top of
+// literals and ranges (a-z), spec may hold the POSIX classes of
+// PosixBracRegexMap ([:upper:]), and Unicode classes, written either [:Lu:],
+// \p{Lu} or, for their complement, \P{Lu}. A backslash takes the character
+// following it literally.
+func ParseRuneSet(spec string) (*RuneSet, error) {
+	return CLocale.ParseRuneSet(spec)
+}
+
+// ParseRuneSet parses spec into a RuneSet the way the package level
+// ParseRuneSet does, with the POSIX classes as they stand in l.
+func (l *Locale) ParseRuneSet(spec string) (*RuneSet, error) {
+	s := &RuneSet{}
+	var prev *setItem
+	for i := 0; i < len(spec); {
+		rest := spec[i:]
+		switch {
+		case strings.HasPrefix(rest, "[:"):
+			end := strings.Index(rest[2:], ":]")
+			if end < 0 {
+				return nil, fmt.Errorf("err: unterminated class in set %q",
+					spec)
+			}
+			name := rest[2 : 2+end]
+			if err := s.addClass(l, name, false); err != nil {
+				return nil, err
+			}
+			i += end + 4
+			prev = nil
+			continue
+		case strings.HasPrefix(rest, `\p`) || strings.HasPrefix(rest, `\P`):
+			name, n, err := parseClassEscape(rest)
+			if err != nil {
+				return nil, err
+			}
+			if err = s.addClass(l, name, rest[1] == 'P'); err != nil {
+				return nil, err
+			}
+			i += n
+			prev = nil
+			continue
+		}
+		c, n, err := setRune(rest)
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		// a hyphen between two characters makes a range of them
+		if c == '-' && n == 1 && prev != nil && i < len(spec) {
+			hi, n, err := setRune(spec[i:])
+			if err != nil {
+				return nil, err
+			}
+			if hi < prev.Lo {
+				return nil, fmt.Errorf("err: range %c-%c in set %q is in "+
+					"reverse order", prev.Lo, hi, spec)
+			}
+			prev.Hi = hi
+			i += n
+			prev = nil
+			continue
+		}
+		s.items = append(s.items, setItem{Lo: c, Hi: c})
+		prev = &s.items[len(s.items)-1]
+	}
+	return s, nil
+}
+
+// setRune decodes the (possibly backslash escaped) character at the start of
+// s, returning it along with how many bytes it takes up. See setEscape for
+// the escapes. Here, \xHH and \NNN stand for the code points U+0000 to
+// U+00FF.
+func setRune(s string) (rune, int, error) {
+	if s[0] == '\\' && len(s) > 1 {
+		c, _, n, err := setEscape(s)
+		return c, n, err
+	}
+	c, n := utf8.DecodeRuneInString(s)
+	if c == utf8.RuneError && n == 1 {
+		return 0, 0, fmt.Errorf("err: invalid UTF-8 in set %q", s)
+	}
+	return c, n, nil
+}
+
+// parseClassEscape parses the \p{Name}, \pN or \P forms at the start of s,
+// returning the class name and how many bytes the escape takes up.
+func parseClassEscape(s string) (string, int, error) {
+	if len(s) < 3 {
+		return "", 0, fmt.Errorf("err: incomplete class escape %q", s)
+	}
+	if s[2] != '{' {
+		return s[2:3], 3, nil
+	}
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return "", 0, fmt.Errorf("err: unterminated class escape %q", s)
+	}
+	return s[3:end], end + 1, nil
+}
+
+// addClass adds the class name to s, first looking it up among the POSIX
+// classes of l and then among the Unicode ones.
+func (s *RuneSet) addClass(l *Locale, name string, negated bool) error {
+	if tables, ok := l.class(name); ok {
+		for _, t := range tables {
+			s.items = append(s.items, setItem{Table: t, Negated: negated})
+		}
+		return nil
+	}
+	if val, ok := PosixBracRegexMap["[:"+name+":]"]; ok && !negated {
+		posix, err := ParseRuneSet(val)
+		if err != nil {
+			return err
+		}
+		s.items = append(s.items, posix.items...)
+		return nil
+	}
+	t, ok := LookupClass(name)
+	if !ok {
+		return fmt.Errorf("err: unknown class %q", name)
+	}
+	s.items = append(s.items, setItem{Table: t, Negated: negated})
+	return nil
+}
+
+// Has reports whether c is a member of s.
+func (s *RuneSet) Has(c rune) bool {
+	for _, it := range s.items {
+		if it.has(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runes lists the members of s, in the order they're written in the set.
+// The members of a class are listed in code point order. A negated class
+// has no such order, so it can't be listed.
+func (s *RuneSet) Runes() ([]rune, error) {
+	var runes []rune
+	for _, it := range s.items {
+		switch {
+		case it.Negated:
+			return nil, fmt.Errorf("err: a negated class has no order to " +
+				"translate by")
+		case it.Table == nil:
+			for c := it.Lo; c <= it.Hi; c++ {
+				runes = append(runes, c)
+			}
+		default:
+			for _, rng := range it.Table.R16 {
+				for c := rune(rng.Lo); c <= rune(rng.Hi); c += rune(rng.Stride) {
+					runes = append(runes, c)
+				}
+			}
+			for _, rng := range it.Table.R32 {
+				for c := rune(rng.Lo); c <= rune(rng.Hi); c += rune(rng.Stride) {
+					runes = append(runes, c)
+				}
+			}
+		}
+	}
+	return runes, nil
+}
+
+// isRuneSpec reports whether spec needs the rune engine: it holds Unicode
+// classes, characters outside of ASCII, or POSIX classes outside of the C
+// locale.
+func isRuneSpec(spec string, l *Locale) bool {
+	if strings.Contains(spec, `\p`) || strings.Contains(spec, `\P`) ||
+		(!l.IsC() && strings.Contains(spec, "[:")) {
+		return true
+	}
+	for i := 0; i < len(spec); i++ {
+		if spec[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	for rest := spec; ; {
+		start := strings.Index(rest, "[:")
+		if start < 0 {
+			return false
+		}
+		end := strings.Index(rest[start:], ":]")
+		if end < 0 {
+			return false
+		}
+		if _, ok := PosixBracRegexMap[rest[start:start+end+2]]; !ok {
+			return true
+		}
+		rest = rest[start+end+2:]
+	}
+}
+
+// escapedRuneSpecs reports whether specs are left to the rune engine for
+// their escapes, which they are unless an escape of a byte past \x7f is
+// among them. Those are left to the byte engine, where they keep standing
+// for bytes.
+func escapedRuneSpecs(specs ...string) bool {
+	escaped := false
+	for _, spec := range specs {
+		e, highByte := specEscapes(spec)
+		if highByte {
+			return false
+		}
+		escaped = escaped || e
+	}
+	return escaped
+}
+
+// specEscapes reports whether spec holds escapes, and whether any of them
+// stands for a byte past \x7f. A malformed escape counts as an escape, so
+// the rune engine gets to report it.
+func specEscapes(spec string) (escaped, highByte bool) {
+	for i := 0; i < len(spec)-1; i++ {
+		if spec[i] != '\\' {
+			continue
+		}
+		c, isByte, n, err := setEscape(spec[i:])
+		if err != nil {
+			return true, false
+		}
+		escaped = true
+		highByte = highByte || (isByte && c >= utf8.RuneSelf)
+		i += n - 1
+	}
+	return escaped, highByte
+}
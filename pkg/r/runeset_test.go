@@ -0,0 +1,160 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestParseRuneSet(t *testing.T) {
+	test := []struct {
+		Spec     string
+		Members  string
+		Excluded string
+	}{
+		{`\p{Greek}`, "Ωμέγα", "abc中"},
+		{`\p{Han}`, "中文", "abcΩ"},
+		{`\pL`, "aΩ中", "1 -"},
+		{`[:Lu:]`, "AΩ", "aω1"},
+		{`\P{ASCII}`, "é中", "a~\x00"},
+		{`[:upper:]0-2x`, "AZ012x", "a3y"},
+		{`a\-z`, "a-z", "b"},
+		{`é-ë`, "éêë", "eè"},
+		{`\x41-\x43`, "ABC", "D@"},
+		{`\u2000-\u200f`, "\u2000\u200b\u200f", " \u2010"},
+		{`\U0001F600\101\n\t`, "😀A\n\t", "n"},
+		// an escaped hyphen is taken literally
+		{`a\x2dc`, "a-c", "b"},
+		// past ASCII, \xHH stands for the code points up to U+00FF
+		{`\xe9`, "é", "\u00e8"},
+	}
+	for i := 0; i < len(test); i++ {
+		s, err := ParseRuneSet(test[i].Spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test[i].Spec, err)
+			continue
+		}
+		for _, c := range test[i].Members {
+			if !s.Has(c) {
+				t.Errorf("%s: expected %q to be a member\n", test[i].Spec, c)
+			}
+		}
+		for _, c := range test[i].Excluded {
+			if s.Has(c) {
+				t.Errorf("%s: expected %q not to be a member\n", test[i].Spec,
+					c)
+			}
+		}
+	}
+	for _, spec := range []string{`\p{Klingon}`, `[:nope:]`, `\p{Lu`,
+		`[:Lu`, "z-a", `\xZ1`, `\x4`, `\u12`, `\uD800`, `\U00110000`,
+		`\400`, `\x1f-\x00`} {
+		if _, err := ParseRuneSet(spec); err == nil {
+			t.Errorf("%s: expected error\n", spec)
+		}
+	}
+}
+
+func TestRuneSetRunes(t *testing.T) {
+	s, err := ParseRuneSet(`\p{Greek}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	runes, err := s.Runes()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the members of a class are listed in code point order
+	for i := 1; i < len(runes); i++ {
+		if runes[i] <= runes[i-1] {
+			t.Fatalf("members out of order: %U before %U", runes[i-1],
+				runes[i])
+		}
+	}
+	if s, _ = ParseRuneSet(`\P{Greek}`); s != nil {
+		if _, err = s.Runes(); err == nil {
+			t.Errorf("expected error listing a negated class")
+		}
+	}
+}
+
+func TestChurnRunes(t *testing.T) {
+	test := []struct {
+		R          R
+		RawString  string
+		DestString string
+	}{
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: `\p{Greek}`}}, "αβ abc γ", " abc "},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: `\p{L}`, Complement: true}}, "a1 é2中!", "aé中"},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_SQUEEZE,
+			SqueezeString: `\p{Zs}é`}}, "éé  a  ée", "é a ée"},
+		// Lu is translated in code point order: A-Z come first
+		{R{From: []byte(`\p{Lu}`), To: []byte("a-z")}, "ABZ", "abz"},
+		{R{From: []byte("αβγ"), To: []byte("abc")}, "γαβ", "cab"},
+		{R{From: []byte("abcd"), To: []byte("xé")}, "abcd", "xééé"},
+		{R{From: []byte("a"), To: []byte("_"), Flag: &Flags{Complement: true}},
+			"abc", "a__"},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &test[i].R
+		r.RawString = test[i].RawString
+		r.Churn(context.Background())
+		if r.DestString != test[i].DestString {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].DestString,
+				r.DestString)
+		}
+	}
+}
+
+func TestStreamRunesInvalidSet(t *testing.T) {
+	r := R{From: []byte(`\p{Klingon}`), To: []byte("x")}
+	err := r.Stream(context.Background(), bytes.NewBufferString("a\n"),
+		&bytes.Buffer{})
+	if err == nil {
+		t.Errorf("expected error for unknown class")
+	}
+}
+
+func TestChurnEscapes(t *testing.T) {
+	test := []struct {
+		R          R
+		RawString  string
+		DestString string
+	}{
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: `\x00-\x1f`}}, "a\x01b\tc\x1f", "abc"},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: `\u200b`}}, "a\u200bb", "ab"},
+		{R{From: []byte(`\n\t`), To: []byte("_")}, "a\tb\n", "a_b_"},
+		// escapes of bytes past \x7f stand for bytes
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: `\200-\377`}}, "caf\xe9", "caf"},
+		{R{From: []byte(`\x80-\xff`), To: []byte(`\x3f`)}, "caf\xe9\xff",
+			"caf??"},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_SQUEEZE,
+			SqueezeBytes: []byte(`\351`)}}, "a\xe9\xe9b", "a\xe9b"},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &test[i].R
+		r.RawString = test[i].RawString
+		r.Churn(context.Background())
+		if r.DestString != test[i].DestString {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].DestString,
+				r.DestString)
+		}
+	}
+}
+
+func TestStreamBadEscape(t *testing.T) {
+	for _, spec := range []string{`\xZZ`, `\377-\200`} {
+		r := R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: spec}}
+		err := r.Stream(context.Background(), bytes.NewBufferString("a\n"),
+			&bytes.Buffer{})
+		if err == nil {
+			t.Errorf("%s: expected error\n", spec)
+		}
+	}
+}
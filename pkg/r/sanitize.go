@@ -0,0 +1,202 @@
+package r
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ControlsStrip   = "strip"
+	ControlsCaret   = "caret"
+	ControlsHex     = "hex"
+	ControlsPicture = "picture"
+)
+
+// maxEscapeLen bounds how far ahead an unterminated escape sequence is
+// looked for before giving up on it, so an OSC missing its terminator
+// doesn't hold back the rest of the input.
+const maxEscapeLen = 4096
+
+// Sanitizer is a Stage cleaning terminal escape sequences, control
+// characters and invalid UTF-8 out of its input. Tabs, newlines and carriage
+// returns are left alone.
+type Sanitizer struct {
+	// ANSI strips CSI, OSC and other escape sequences as a whole
+	ANSI bool
+	// Controls defines what happens to the remaining C0 and C1 control
+	// characters: ControlsStrip removes them, ControlsCaret shows them as ^A,
+	// ControlsHex as \x01 and ControlsPicture as their Unicode control picture
+	// (U+2401). Empty leaves them alone.
+	Controls string
+	// UTF8 strips bytes that aren't part of valid UTF-8
+	UTF8 bool
+	held []byte
+}
+
+// ParseSanitizer parses a comma separated list of sanitizer options: ansi,
+// controls[=strip|caret|hex|picture] and utf8.
+func ParseSanitizer(opts string) (*Sanitizer, error) {
+	s := &Sanitizer{}
+	for _, opt := range strings.Split(opts, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		switch name {
+		case "ansi":
+			s.ANSI = true
+		case "utf8":
+			s.UTF8 = true
+		case "controls":
+			switch val {
+			case "":
+				s.Controls = ControlsStrip
+			case ControlsStrip, ControlsCaret, ControlsHex, ControlsPicture:
+				s.Controls = val
+			default:
+				return nil, fmt.Errorf("err: unknown controls mode %q. "+
+					"expecting %s, %s, %s or %s", val, ControlsStrip,
+					ControlsCaret, ControlsHex, ControlsPicture)
+			}
+		default:
+			return nil, fmt.Errorf("err: unknown sanitize option %q", opt)
+		}
+	}
+	return s, nil
+}
+
+// Process implements Stage.
+func (s *Sanitizer) Process(b []byte) []byte {
+	b = append(s.held, b...)
+	s.held = nil
+	return s.run(b, false)
+}
+
+// Flush implements Stage.
+func (s *Sanitizer) Flush() []byte {
+	b := s.held
+	s.held = nil
+	return s.run(b, true)
+}
+
+// run sanitizes b. Unless final is set, an incomplete escape sequence or
+// UTF-8 encoding at the end of b is held back for the next call.
+func (s *Sanitizer) run(b []byte, final bool) []byte {
+	buffer := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		if b[i] == 0x1b && s.ANSI {
+			n := escapeLen(b[i:])
+			if n < 0 && !final {
+				s.held = append(s.held, b[i:]...)
+				break
+			}
+			if n > 0 {
+				i += n
+				continue
+			}
+		}
+		c, size := utf8.DecodeRune(b[i:])
+		if c == utf8.RuneError && size == 1 {
+			if !final && !utf8.FullRune(b[i:]) {
+				s.held = append(s.held, b[i:]...)
+				break
+			}
+			if !s.UTF8 {
+				buffer = append(buffer, b[i])
+			}
+			i++
+			continue
+		}
+		if s.Controls != "" && isControl(c) {
+			buffer = s.appendControl(buffer, c)
+		} else {
+			buffer = append(buffer, b[i:i+size]...)
+		}
+		i += size
+	}
+	return buffer
+}
+
+// isControl reports whether c is a C0 or C1 control character, other than
+// a tab, newline or carriage return.
+func isControl(c rune) bool {
+	switch {
+	case c == '\t' || c == '\n' || c == '\r':
+		return false
+	case c < 0x20 || c == 0x7f:
+		return true
+	}
+	return c >= 0x80 && c <= 0x9f
+}
+
+// appendControl appends the control character c to buffer in the form
+// selected by Controls.
+func (s *Sanitizer) appendControl(buffer []byte, c rune) []byte {
+	switch {
+	case s.Controls == ControlsStrip:
+		return buffer
+	case s.Controls == ControlsCaret && c == 0x7f:
+		return append(buffer, "^?"...)
+	case s.Controls == ControlsCaret && c < 0x20:
+		return append(buffer, '^', byte(c)+0x40)
+	case s.Controls == ControlsCaret:
+		return append(buffer, "M-^"+string(rune(c-0x80+0x40))...)
+	case s.Controls == ControlsPicture && c == 0x7f:
+		return utf8.AppendRune(buffer, 0x2421)
+	case s.Controls == ControlsPicture && c < 0x20:
+		return utf8.AppendRune(buffer, 0x2400+c)
+	case c < 0x80:
+		return append(buffer, fmt.Sprintf("\\x%02x", c)...)
+	}
+	return append(buffer, fmt.Sprintf("\\u%04x", c)...)
+}
+
+// escapeLen returns the length of the escape sequence starting at b[0],
+// which must be ESC. It returns 0 if b doesn't hold a well formed sequence,
+// and -1 if the sequence is cut short by the end of b.
+func escapeLen(b []byte) int {
+	if len(b) < 2 {
+		return -1
+	}
+	switch b[1] {
+	case '[':
+		// CSI: parameter and intermediate bytes, then a final byte
+		j := 2
+		for j < len(b) && b[j] >= 0x20 && b[j] <= 0x3f {
+			j++
+		}
+		switch {
+		case j == len(b):
+			return -1
+		case b[j] >= 0x40 && b[j] <= 0x7e:
+			return j + 1
+		}
+		return 0
+	case ']', 'P', '_', '^':
+		// OSC and other strings, terminated by BEL or ST (ESC \)
+		for j := 2; j < len(b) && j < maxEscapeLen; j++ {
+			switch {
+			case b[j] == 0x07 && b[1] == ']':
+				return j + 1
+			case b[j] == 0x1b && j+1 == len(b):
+				return -1
+			case b[j] == 0x1b && b[j+1] == '\\':
+				return j + 2
+			}
+		}
+		if len(b) < maxEscapeLen {
+			return -1
+		}
+		return 0
+	}
+	// any other sequence: intermediate bytes, then a final byte
+	j := 1
+	for j < len(b) && b[j] >= 0x20 && b[j] <= 0x2f {
+		j++
+	}
+	switch {
+	case j == len(b):
+		return -1
+	case b[j] >= 0x30 && b[j] <= 0x7e:
+		return j + 1
+	}
+	return 0
+}
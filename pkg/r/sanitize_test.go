@@ -0,0 +1,68 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestSanitizer(t *testing.T) {
+	test := []struct {
+		Opts       string
+		RawString  string
+		DestString string
+	}{
+		{"ansi", "\x1b[1;31mred\x1b[0m", "red"},
+		{"ansi", "\x1b]0;title\x07a\x1b]8;;http://x\x1b\\b", "ab"},
+		{"ansi", "\x1b(Bx\x1bc", "x"},
+		{"controls", "a\x00b\tc\x7f\r\n", "ab\tc\r\n"},
+		{"controls=caret", "\x01\x1b\x7f\u0085", "^A^[^?M-^E"},
+		{"controls=hex", "\x01\u0085", `\x01\u0085`},
+		{"controls=picture", "\x01\x7f", "␁␡"},
+		{"utf8", "a\xffb\xc3", "ab"},
+		{"ansi,controls", "\x1b[31mx\x1b[", "x["},
+		{"ansi", "no escapes é", "no escapes é"},
+	}
+	for i := 0; i < len(test); i++ {
+		s, err := ParseSanitizer(test[i].Opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got := append(s.Process([]byte(test[i].RawString)), s.Flush()...)
+		if string(got) != test[i].DestString {
+			t.Errorf("%s: expected %q. got %q\n", test[i].Opts,
+				test[i].DestString, got)
+		}
+	}
+	if _, err := ParseSanitizer("ansi,bogus"); err == nil {
+		t.Errorf("expected error for unknown option")
+	}
+}
+
+func TestSanitizerSplitChunks(t *testing.T) {
+	s, _ := ParseSanitizer("ansi,utf8")
+	raw := []byte("a\x1b[38;5;196mb\x1b]0;t\x07cé\x1b[0m")
+	// feed the input a byte at a time, so every sequence gets split
+	var got []byte
+	for i := range raw {
+		got = append(got, s.Process(raw[i:i+1])...)
+	}
+	got = append(got, s.Flush()...)
+	if string(got) != "abcé" {
+		t.Errorf("expected %q. got %q\n", "abcé", got)
+	}
+}
+
+func TestStreamSanitize(t *testing.T) {
+	r := R{From: []byte("a-z"), To: []byte("A-Z"),
+		Flag: &Flags{Sanitize: "ansi"}}
+	var out bytes.Buffer
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("\x1b[31mred\x1b[0m\n"), &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "RED\n" {
+		t.Errorf("expected %q. got %q\n", "RED\n", out.String())
+	}
+}
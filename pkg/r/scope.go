@@ -0,0 +1,93 @@
+package r
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Scope restricts processing to part of each line: either the spans matched
+// by a regular expression, or, when Outside is set, everything but those spans.
+type Scope struct {
+	// Re is the expression delimiting the scope
+	Re *regexp.Regexp
+	// Group is the index of the capture group of Re that delimits the scope.
+	// Zero means the whole match.
+	Group int
+	// Outside inverts the scope to the parts of the line Re doesn't match
+	Outside bool
+}
+
+// NewScope compiles expr into a Scope. group, if not empty, names the capture
+// group within expr that should be used in place of the whole match.
+func NewScope(expr, group string, outside bool) (*Scope, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("err: could not compile scope regex %q: %w",
+			expr, err)
+	}
+	s := &Scope{Re: re, Outside: outside}
+	if group != "" {
+		s.Group = re.SubexpIndex(group)
+		if s.Group < 0 {
+			return nil, fmt.Errorf("err: scope regex %q has no group named %q",
+				expr, group)
+		}
+	}
+	return s, nil
+}
+
+// Apply runs fn over the in-scope parts of line, copying the remainder to the
+// result untouched.
+func (s *Scope) Apply(line []byte, fn func([]byte) []byte) []byte {
+	return s.applyAt(line, offsetAt(0), func(b []byte, _ position) []byte {
+		return fn(b)
+	})
+}
+
+// applyAt implements Apply for a line found at in its record, telling fn
+// where each part it's handed is found.
+func (s *Scope) applyAt(line []byte, at position,
+	fn func([]byte, position) []byte) []byte {
+	buffer := make([]byte, 0, len(line))
+	prev := 0
+	for _, loc := range s.Re.FindAllSubmatchIndex(line, -1) {
+		start, end := loc[2*s.Group], loc[2*s.Group+1]
+		if start < 0 || start == end {
+			continue
+		}
+		buffer = append(buffer, s.part(line[prev:start], s.Outside,
+			at.shift(prev), fn)...)
+		buffer = append(buffer, s.part(line[start:end], !s.Outside,
+			at.shift(start), fn)...)
+		prev = end
+	}
+	return append(buffer, s.part(line[prev:], s.Outside, at.shift(prev),
+		fn)...)
+}
+
+// part returns b, found at, transformed by fn if it's in scope, and b as is
+// otherwise.
+func (s *Scope) part(b []byte, in bool, at position,
+	fn func([]byte, position) []byte) []byte {
+	if !in || len(b) == 0 {
+		return b
+	}
+	return fn(b, at)
+}
+
+// scope builds the Scope configured by the Within/Outside flags, returning nil
+// if neither is set.
+func (f *Flags) scope() (*Scope, error) {
+	switch {
+	case f == nil:
+		return nil, nil
+	case f.Within != "" && f.Outside != "":
+		return nil, fmt.Errorf("err: --within and --outside are mutually" +
+			" exclusive")
+	case f.Within != "":
+		return NewScope(f.Within, f.Group, false)
+	case f.Outside != "":
+		return NewScope(f.Outside, f.Group, true)
+	}
+	return nil, nil
+}
@@ -0,0 +1,53 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestScopeApply(t *testing.T) {
+	test := []struct {
+		Expr, Group string
+		Outside     bool
+		RawString   string
+		DestString  string
+	}{
+		{`"[^"]*"`, "", false, "ab \"ab\" ab\n", "ab \"AB\" ab\n"},
+		{`"[^"]*"`, "", true, "ab \"ab\" ab\n", "AB \"ab\" AB\n"},
+		{`host=(?P<h>\S+)`, "h", false, "host=web id=abc\n", "host=WEB id=abc\n"},
+		{`x*`, "", false, "abc\n", "abc\n"},
+		{`zzz`, "", true, "abc\n", "ABC\n"},
+	}
+	for i := 0; i < len(test); i++ {
+		s, err := NewScope(test[i].Expr, test[i].Group, test[i].Outside)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got := s.Apply([]byte(test[i].RawString), bytes.ToUpper)
+		if string(got) != test[i].DestString {
+			t.Errorf("expected %q. got %q\n", test[i].DestString, got)
+		}
+	}
+}
+
+func TestNewScopeUnknownGroup(t *testing.T) {
+	if _, err := NewScope(`(?P<a>x)`, "b", false); err == nil {
+		t.Errorf("expected error for unknown group")
+	}
+}
+
+func TestStreamWithin(t *testing.T) {
+	r := R{From: []byte("a-z"), To: []byte("A-Z"),
+		Flag: &Flags{Within: `\[[^]]*\]`}}
+	var out bytes.Buffer
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("[info] started\n[warn] slow"), &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "[INFO] started\n[WARN] slow" {
+		t.Errorf("expected %q. got %q\n", "[INFO] started\n[WARN] slow",
+			out.String())
+	}
+}
@@ -0,0 +1,224 @@
+package r
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Script is a list of operations read from a script file (-f), run in turn
+// over every line of the input, in a single pass. Every line of the file
+// holds an operation and its arguments, eg:
+//
+//	# tidy up a CSV export
+//	eol lf
+//	delete '\r'
+//	translate a-z A-Z
+//	replace "N/A" ""
+//
+// Arguments are separated by blanks. Within single quotes, every character
+// stands for itself. Within double quotes, \" and \\ stand for a quote and a
+// backslash, and any other backslash is kept for the operation (eg: the
+// escapes of a set). A # starting a word starts a comment.
+type Script struct {
+	// Name is the name of the file the script was read from
+	Name string
+	Ops  []ScriptOp
+}
+
+// ScriptOp is an operation of a Script.
+type ScriptOp struct {
+	// Line is the line of the script the operation is on
+	Line int
+	Name string
+	Args []string
+	// Flag, if set, is the flag that gave the operation in place of a line
+	// of the script, eg: --stage. Line counts its occurrences then.
+	Flag string
+}
+
+// LoadScript reads the script file at path.
+func LoadScript(path string) (*Script, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("err: reading script: %w", err)
+	}
+	defer file.Close()
+	return ParseScript(path, file)
+}
+
+// ParseScript parses the script read from src, named name. Errors give the
+// line they were found on.
+func ParseScript(name string, src io.Reader) (*Script, error) {
+	s := &Script{Name: name}
+	sc := bufio.NewScanner(src)
+	for n := 1; sc.Scan(); n++ {
+		words, err := splitScriptLine(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if _, err = lookupOperation(words[0], len(words)-1); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		s.Ops = append(s.Ops, ScriptOp{Line: n, Name: words[0],
+			Args: words[1:]})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("err: reading script: %w", err)
+	}
+	return s, nil
+}
+
+// splitScriptLine splits a line of a script into words, see Script.
+func splitScriptLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '#' && !inWord:
+			return words, nil
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("err: unterminated quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) &&
+					(line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, fmt.Errorf("err: unterminated quote")
+			}
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Compile builds the Pipeline running the operations of s in turn, out of
+// the operations registered with Register. The arguments left out of the
+// operations of s are set to their defaults.
+func (s *Script) Compile(env *Env) (Pipeline, error) {
+	p := make(Pipeline, 0, len(s.Ops))
+	for i, op := range s.Ops {
+		stage, err := Build(op.Name, op.Args, env)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.where(op), err)
+		}
+		if reg, ok := Lookup(op.Name); ok {
+			s.Ops[i].Args = reg.withDefaults(op.Args)
+		}
+		p = append(p, stage)
+	}
+	return p, nil
+}
+
+// where returns where op is from: the line of s, or the flag.
+func (s *Script) where(op ScriptOp) string {
+	if op.Flag != "" {
+		return fmt.Sprintf("%s %d", op.Flag, op.Line)
+	}
+	return fmt.Sprintf("%s:%d", s.Name, op.Line)
+}
+
+// Dump writes out the operations of s in the order they run, one per line,
+// along with the line of the script (or the flag) each one is from. The
+// arguments are quoted so that the output reads back as a script.
+func (s *Script) Dump(w io.Writer) error {
+	for i, op := range s.Ops {
+		words := []string{op.Name}
+		for _, arg := range op.Args {
+			words = append(words, quoteScriptArg(arg))
+		}
+		_, err := fmt.Fprintf(w, "%s\t# %d, %s\n", strings.Join(words, " "),
+			i+1, s.where(op))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opScript returns the operations of the -f script and --stage selected by
+// f as a single Script, in the order they run. They're checked against the
+// registrations, but not built.
+func (f *Flags) opScript() (*Script, error) {
+	s := &Script{}
+	if f.Script != "" {
+		var err error
+		if s, err = LoadScript(f.Script); err != nil {
+			return nil, err
+		}
+	}
+	stages, err := f.stageScript()
+	if err != nil {
+		return nil, err
+	}
+	s.Ops = append(s.Ops, stages.Ops...)
+	return s, nil
+}
+
+// DumpOps compiles the operations of the -f script and --stage selected by
+// f with env, and writes them out as compiled, in the order they run ahead
+// of the operation, see Script.Dump.
+func (f *Flags) DumpOps(w io.Writer, env *Env) error {
+	s, err := f.opScript()
+	if err != nil {
+		return err
+	}
+	if _, err = s.Compile(env); err != nil {
+		return err
+	}
+	return s.Dump(w)
+}
+
+// quoteScriptArg quotes arg, if needed, so that it reads back as a single
+// word of a script.
+func quoteScriptArg(arg string) string {
+	switch {
+	case arg != "" && !strings.ContainsAny(arg, " \t\r'\"#"):
+		return arg
+	case !strings.Contains(arg, "'"):
+		return "'" + arg + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// script returns the pipeline of the script selected by the flags, or nil.
+func (f *Flags) script(env *Env) (Pipeline, error) {
+	if f.Script == "" {
+		return nil, nil
+	}
+	s, err := LoadScript(f.Script)
+	if err != nil {
+		return nil, err
+	}
+	return s.Compile(env)
+}
@@ -0,0 +1,190 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitScriptLine(t *testing.T) {
+	test := []struct {
+		Line     string
+		Expected []string
+	}{
+		{"translate a-z A-Z", []string{"translate", "a-z", "A-Z"}},
+		{"  delete\t'\\r'  ", []string{"delete", `\r`}},
+		{`replace "N/A" ""`, []string{"replace", "N/A", ""}},
+		{`replace "say \"hi\"" 'a b'`, []string{"replace", `say "hi"`, "a b"}},
+		{`delete "\\\n"`, []string{"delete", `\\n`}},
+		{"squeeze ' ' # runs of blanks", []string{"squeeze", " "}},
+		{"delete a#b", []string{"delete", "a#b"}},
+		{"delete '#'", []string{"delete", "#"}},
+		{"# a comment", nil},
+		{"", nil},
+		{"x'a b'c", []string{"xa bc"}},
+	}
+	for i := 0; i < len(test); i++ {
+		got, err := splitScriptLine(test[i].Line)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test[i].Expected) {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, got)
+		}
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	test := []struct {
+		Script, Expected string
+	}{
+		{"translate a-z A-Z\n\nfrob x\n", "s.tr:3: err: unknown operation"},
+		{"delete\n", "s.tr:1: err: expecting delete SET"},
+		{"eol lf\ntranslate a b c\n", "s.tr:2: err: expecting translate SET1 SET2"},
+		{"# quoting\nreplace 'a b\n", "s.tr:2: err: unterminated quote"},
+	}
+	for i := 0; i < len(test); i++ {
+		_, err := ParseScript("s.tr", strings.NewReader(test[i].Script))
+		if err == nil || !strings.Contains(err.Error(), test[i].Expected) {
+			t.Errorf("%d: expected error %q. got %v\n", i, test[i].Expected,
+				err)
+		}
+	}
+}
+
+func TestCompileScriptErrors(t *testing.T) {
+	test := []struct {
+		Script, Expected string
+	}{
+		{"eol lf\ndelete '\\xZZ'\n", "s.tr:2: "},
+		{"case shouting\n", "s.tr:1: "},
+		{"regex '(' x\n", "s.tr:1: "},
+		{"replace '' x\n", "s.tr:1: "},
+	}
+	for i := 0; i < len(test); i++ {
+		s, err := ParseScript("s.tr", strings.NewReader(test[i].Script))
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		_, err = s.Compile(nil)
+		if err == nil || !strings.HasPrefix(err.Error(), test[i].Expected) {
+			t.Errorf("%d: expected error %q. got %v\n", i, test[i].Expected,
+				err)
+		}
+	}
+}
+
+func TestStreamScript(t *testing.T) {
+	test := []struct {
+		R        R
+		Flags    Flags
+		Script   string
+		Raw      string
+		Expected string
+	}{
+		{R{}, Flags{}, "eol lf\ndelete '\\r'\ntranslate a-z A-Z\n",
+			"ab\r\ncd\r\n", "AB\nCD\n"},
+		// every operation runs on the result of the one before
+		{R{}, Flags{}, "replace cat dog\ntranslate dog cat\n", "cat\n",
+			"cat\n"},
+		{R{}, Flags{}, "squeeze ' '\nreplace ' ' ,\n", "a   b  c\n", "a,b,c\n"},
+		{R{}, Flags{}, "squeeze '\\n'\n", "a\n\n\nb\n\n", "a\nb\n"},
+		{R{}, Flags{}, "squeeze '\\p{Zs}\\n'\n", "a\n\n\u3000\u3000b\n",
+			"a\n\u3000b\n"},
+		{R{}, Flags{}, "delete '\\n'\n", "a\nb\nc", "abc"},
+		{R{}, Flags{}, "regex '(\\d+)-(\\d+)' '$2-$1'\nreplace $ USD\n",
+			"1-2 $\n", "2-1 USD\n"},
+		{R{}, Flags{Locale: "tr_TR"}, "case upper\n", "istanbul\n",
+			"İSTANBUL\n"},
+		{R{}, Flags{}, "delete '\\p{Nd}'\nto-ascii\n", "café 42\n",
+			"cafe \n"},
+		// the script runs ahead of SET1 and SET2
+		{R{From: []byte("x"), To: []byte("y")}, Flags{}, "replace a x\n",
+			"abc\n", "ybc\n"},
+	}
+	dir := t.TempDir()
+	for i := 0; i < len(test); i++ {
+		path := filepath.Join(dir, "s.tr")
+		if err := os.WriteFile(path, []byte(test[i].Script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r := &test[i].R
+		test[i].Flags.Script = path
+		r.Flag = &test[i].Flags
+		out := &bytes.Buffer{}
+		err := r.Stream(context.Background(), bytes.NewBufferString(test[i].Raw),
+			out)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if out.String() != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, out)
+		}
+	}
+}
+
+func TestDumpScript(t *testing.T) {
+	src := "# a comment\neol lf\n\ndelete '\\r'\nreplace \"N/A\" ''\n" +
+		"replace \"it's\" \"a \\\"b\\\"\"\n"
+	s, err := ParseScript("s.tr", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err = s.Dump(out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "eol lf\t# 1, s.tr:2\n" +
+		"delete \\r\t# 2, s.tr:4\n" +
+		"replace N/A ''\t# 3, s.tr:5\n" +
+		"replace \"it's\" 'a \"b\"'\t# 4, s.tr:6\n"
+	if out.String() != expected {
+		t.Errorf("expected %q. got %q\n", expected, out)
+	}
+	// the dump reads back as the same script
+	again, err := ParseScript("s.tr", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range s.Ops {
+		if !reflect.DeepEqual(s.Ops[i].Args, again.Ops[i].Args) {
+			t.Errorf("%d: expected %q. got %q\n", i, s.Ops[i].Args,
+				again.Ops[i].Args)
+		}
+	}
+}
+
+func TestDumpOps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.tr")
+	err := os.WriteFile(path, []byte("sanitize\nregex x\nto-ascii '*'\n"),
+		0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Flags{Script: path, Stages: []string{"mask", "case upper"}}
+	out := &bytes.Buffer{}
+	if err = f.DumpOps(out, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the defaults are filled in, and --stage runs after the script
+	expected := "sanitize ansi,controls\t# 1, " + path + ":1\n" +
+		"regex x ''\t# 2, " + path + ":2\n" +
+		"to-ascii *\t# 3, " + path + ":3\n" +
+		"mask all\t# 4, --stage 1\n" +
+		"case upper\t# 5, --stage 2\n"
+	if out.String() != expected {
+		t.Errorf("expected %q. got %q\n", expected, out)
+	}
+	f = &Flags{Stages: []string{"translate a-z A-Z", "case shouting"}}
+	err = f.DumpOps(&bytes.Buffer{}, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "--stage 2: ") {
+		t.Errorf("expected error %q. got %v\n", "--stage 2: ", err)
+	}
+}
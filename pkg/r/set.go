@@ -0,0 +1,221 @@
+package r
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ByteSet is a compiled set membership table, holding an entry for every
+// possible byte value so lookups take constant time.
+type ByteSet [256]bool
+
+// NewByteSet compiles the bytes of b into a ByteSet.
+func NewByteSet(b []byte) *ByteSet {
+	s := &ByteSet{}
+	for _, c := range b {
+		s[c] = true
+	}
+	return s
+}
+
+// Has reports whether c is a member of s.
+func (s *ByteSet) Has(c byte) bool {
+	return s[c]
+}
+
+// ByteMap is a compiled translation table, holding the replacement of every
+// possible byte value so a translation takes a single lookup per byte. It's
+// the fast path of range translations and byte for byte code page
+// conversions.
+type ByteMap struct {
+	to  [256]byte
+	set ByteSet
+}
+
+// NewByteMap compiles the translation of the bytes of from into those of to
+// at the same index. A byte repeated in from translates by its first index.
+func NewByteMap(from, to []byte) *ByteMap {
+	m := &ByteMap{}
+	for i := 0; i < len(from) && i < len(to); i++ {
+		if !m.set[from[i]] {
+			m.to[from[i]], m.set[from[i]] = to[i], true
+		}
+	}
+	return m
+}
+
+// Map translates b in place, recording what it does into stats and where
+// into marks, both of which may be nil. Only the bytes selected by limit,
+// which may be nil, are translated.
+func (m *ByteMap) Map(b []byte, stats *Stats, limit *limit, marks *marks) {
+	for i, c := range b {
+		if m.set[c] && limit.next() {
+			stats.translated(charKey(c))
+			marks.add(i, b[i:i+1])
+			b[i] = m.to[c]
+		}
+	}
+}
+
+// Process implements Stage.
+func (m *ByteMap) Process(b []byte) []byte {
+	buffer := append([]byte(nil), b...)
+	m.Map(buffer, nil, nil, nil)
+	return buffer
+}
+
+// Flush implements Stage.
+func (m *ByteMap) Flush() []byte {
+	return nil
+}
+
+// Set compiles SET1 of the operation configured on r, that is the
+// characters it deletes, squeezes or translates, into a ByteSet. It's SET1
+// as the byte engine reads it; the rune engine compiles a RuneSet instead.
+func (r *R) Set() (*ByteSet, error) {
+	set := r.From
+	if r.FlagEnabled {
+		switch r.Flag.Action {
+		case Action_DELETE:
+			set = []byte(r.Flag.DelString)
+		case Action_SQUEEZE:
+			set = r.Flag.SqueezeBytes
+		}
+	}
+	if escaped, _ := specEscapes(string(set)); escaped {
+		list, err := parseByteSpec(string(set))
+		if err != nil {
+			return nil, err
+		}
+		return NewByteSet(list), nil
+	}
+	if r.FlagEnabled && r.Flag.Action == Action_SQUEEZE {
+		return NewByteSet(set), nil
+	}
+	if val, ok := PosixBracRegexMap[string(set)]; ok {
+		set = []byte(val)
+	} else if r.FlagEnabled && r.Flag.Action == Action_DELETE {
+		// the delete string is taken literally unless it's a class
+		return NewByteSet(set), nil
+	}
+	if isRange(set) {
+		var err error
+		if set, err = resolveRange(set); err != nil {
+			return nil, err
+		}
+	}
+	return NewByteSet(set), nil
+}
+
+// setEscapes are the escapes of a single character in a set.
+var setEscapes = map[byte]rune{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t',
+	'v': '\v',
+}
+
+// setEscape decodes the escape sequence at the start of s, which must be a
+// backslash followed by at least one byte. The sequence is one of \xHH or
+// \NNN in octal, standing for a byte (isByte is set), \uXXXX or \U00XXXXXX
+// standing for a code point, one of \a, \b, \f, \n, \r, \t or \v, or a
+// backslash taking the character following it literally. n is how many bytes
+// the sequence takes up.
+func setEscape(s string) (c rune, isByte bool, n int, err error) {
+	switch {
+	case s[1] == 'x':
+		if len(s) < 4 || !isDigits(s[2:4], 16) {
+			return 0, false, 0, fmt.Errorf("err: malformed escape %q. "+
+				"expecting \\xHH", prefix(s, 4))
+		}
+		v, _ := strconv.ParseUint(s[2:4], 16, 8)
+		return rune(v), true, 4, nil
+	case s[1] == 'u' || s[1] == 'U':
+		want := 4
+		if s[1] == 'U' {
+			want = 8
+		}
+		if len(s) < 2+want || !isDigits(s[2:2+want], 16) {
+			return 0, false, 0, fmt.Errorf("err: malformed escape %q. "+
+				"expecting \\%c followed by %d hex digits", prefix(s, 2+want),
+				s[1], want)
+		}
+		v, _ := strconv.ParseUint(s[2:2+want], 16, 32)
+		if !utf8.ValidRune(rune(v)) {
+			return 0, false, 0, fmt.Errorf("err: escape %q is not a valid "+
+				"code point", s[:2+want])
+		}
+		return rune(v), false, 2 + want, nil
+	case s[1] >= '0' && s[1] <= '7':
+		n = 2
+		for n < len(s) && n < 4 && s[n] >= '0' && s[n] <= '7' {
+			n++
+		}
+		v, _ := strconv.ParseUint(s[1:n], 8, 16)
+		if v > 0xff {
+			return 0, false, 0, fmt.Errorf("err: octal escape %q is past "+
+				"\\377", s[:n])
+		}
+		return rune(v), true, n, nil
+	}
+	if c, ok := setEscapes[s[1]]; ok {
+		return c, false, 2, nil
+	}
+	c, size := utf8.DecodeRuneInString(s[1:])
+	return c, false, size + 1, nil
+}
+
+// isDigits reports whether s is made up of digits of base only.
+func isDigits(s string, base int) bool {
+	_, err := strconv.ParseUint(s, base, 64)
+	return err == nil
+}
+
+// prefix returns s cut to n bytes at most.
+func prefix(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// parseByteSpec parses spec for the byte engine into the bytes it lists, in
+// order. spec is made up of characters, escapes, where \xHH and \NNN stand
+// for bytes, and ranges of either (\200-\377).
+func parseByteSpec(spec string) ([]byte, error) {
+	byteAt := func(i int) (byte, int, error) {
+		if spec[i] != '\\' || i+1 == len(spec) {
+			return spec[i], 1, nil
+		}
+		c, isByte, n, err := setEscape(spec[i:])
+		if err == nil && !isByte && c >= utf8.RuneSelf {
+			err = fmt.Errorf("err: escape %q doesn't fit in a byte", spec[i:i+n])
+		}
+		return byte(c), n, err
+	}
+	var list []byte
+	for i := 0; i < len(spec); {
+		start := i
+		lo, n, err := byteAt(i)
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		if i+1 >= len(spec) || spec[i] != '-' {
+			list = append(list, lo)
+			continue
+		}
+		hi, n, err := byteAt(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("err: range %q in set %q is in reverse "+
+				"order", spec[start:i+1+n], spec)
+		}
+		for c := int(lo); c <= int(hi); c++ {
+			list = append(list, byte(c))
+		}
+		i += 1 + n
+	}
+	return list, nil
+}
@@ -0,0 +1,39 @@
+package r
+
+// squeezeRun carries the state of squeezing from one call of Apply to the
+// next, so a run of repeats spanning several lines, like the empty lines of
+// -s '\n', is squeezed as a whole.
+type squeezeRun struct {
+	// prev is the last character seen, a byte for the byte engine or a rune
+	// for the rune engine, and -1 if there's none
+	prev rune
+	// run is set while squeezing away the repeats of prev
+	run bool
+}
+
+// newSqueezeRun returns a squeezeRun with no character seen yet.
+func newSqueezeRun() *squeezeRun {
+	return &squeezeRun{prev: -1}
+}
+
+// load returns the state left by the previous call. A nil squeezeRun starts
+// afresh every time.
+func (s *squeezeRun) load() (rune, bool) {
+	if s == nil {
+		return -1, false
+	}
+	return s.prev, s.run
+}
+
+// save keeps the state for the next call.
+func (s *squeezeRun) save(prev rune, run bool) {
+	if s != nil {
+		s.prev, s.run = prev, run
+	}
+}
+
+// reset forgets the last character, as when input not squeezed comes in
+// between.
+func (s *squeezeRun) reset() {
+	s.save(-1, false)
+}
@@ -0,0 +1,44 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestStreamSqueeze(t *testing.T) {
+	test := []struct {
+		Flags       Flags
+		Raw         string
+		Expected    string
+		SqueezeRuns int64
+	}{
+		{Flags{SqueezeBytes: []byte("\n")}, "a\n\n\nb\n", "a\nb\n", 1},
+		{Flags{SqueezeBytes: []byte("\n")}, "\n\n\na\n\nb\n\n", "\na\nb\n", 3},
+		// the rune engine
+		{Flags{SqueezeString: "ab", Complement: true}, "a\n\n\nb\n", "a\nb\n", 1},
+		{Flags{SqueezeString: "\né"}, "é\néé\n\n", "é\né\n", 2},
+		// a run is cut by the lines not addressed
+		{Flags{SqueezeBytes: []byte("\n"), Lines: "1"}, "\n\n\n", "\n\n\n", 0},
+		{Flags{SqueezeBytes: []byte("\n"), Lines: "2,$"}, "a\n\n\n\n", "a\n\n",
+			1},
+	}
+	for i := 0; i < len(test); i++ {
+		test[i].Flags.Action = Action_SQUEEZE
+		r := &R{FlagEnabled: true, Flag: &test[i].Flags, Stats: NewStats()}
+		out := &bytes.Buffer{}
+		err := r.Stream(context.Background(), bytes.NewBufferString(test[i].Raw),
+			out)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+			continue
+		}
+		if out.String() != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, out)
+		}
+		if r.Stats.SqueezeRuns != test[i].SqueezeRuns {
+			t.Errorf("%d: expected %d runs. got %d\n", i, test[i].SqueezeRuns,
+				r.Stats.SqueezeRuns)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package r
+
+import (
+	"fmt"
+	"io"
+)
+
+// Stage is a step of the processing pipeline run by Stream, either ahead of
+// the operation configured on r or after it. Process is handed the input
+// chunk by chunk and returns the output that's ready. A stage may hold back
+// the tail of a chunk it can only deal with once more input is seen (eg: an
+// escape sequence split across chunks); Flush is called at the end of the
+// input to return whatever is still held back.
+type Stage interface {
+	Process(b []byte) []byte
+	Flush() []byte
+}
+
+// failer is implemented by the stages that can fail, such as an Encoder
+// meeting a character it can't encode. Err returns the error that stopped
+// the stage, if any.
+type failer interface {
+	Err() error
+}
+
+// Pipeline chains stages, feeding the output of each into the next.
+type Pipeline []Stage
+
+// Process runs b through every stage of p.
+func (p Pipeline) Process(b []byte) []byte {
+	for _, s := range p {
+		b = s.Process(b)
+	}
+	return b
+}
+
+// Flush flushes every stage of p, running what each one returns through the
+// stages that follow it.
+func (p Pipeline) Flush() []byte {
+	var b []byte
+	for _, s := range p {
+		b = append(s.Process(b), s.Flush()...)
+	}
+	return b
+}
+
+// Err returns the error that stopped the first failed stage of p, if any.
+func (p Pipeline) Err() error {
+	for _, s := range p {
+		if f, ok := s.(failer); ok && f.Err() != nil {
+			return f.Err()
+		}
+	}
+	return nil
+}
+
+// stageReader reads from src through the stages of p.
+type stageReader struct {
+	src    io.Reader
+	p      Pipeline
+	buffer []byte
+	eof    bool
+}
+
+func (sr *stageReader) Read(b []byte) (int, error) {
+	for len(sr.buffer) == 0 {
+		if sr.eof {
+			return 0, io.EOF
+		}
+		chunk := make([]byte, 32*1024)
+		n, err := sr.src.Read(chunk)
+		sr.buffer = append(sr.buffer, sr.p.Process(chunk[:n])...)
+		if err == io.EOF {
+			sr.eof = true
+			sr.buffer = append(sr.buffer, sr.p.Flush()...)
+		} else if err != nil {
+			return 0, err
+		}
+		if err := sr.p.Err(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, sr.buffer)
+	sr.buffer = sr.buffer[n:]
+	return n, nil
+}
+
+// stageWriter writes to dst through the stages of p. Close must be called
+// once done writing to flush the stages.
+type stageWriter struct {
+	dst io.Writer
+	p   Pipeline
+}
+
+func (sw *stageWriter) Write(b []byte) (int, error) {
+	if _, err := sw.dst.Write(sw.p.Process(b)); err != nil {
+		return 0, err
+	}
+	if err := sw.p.Err(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (sw *stageWriter) Close() error {
+	if _, err := sw.dst.Write(sw.p.Flush()); err != nil {
+		return err
+	}
+	return sw.p.Err()
+}
+
+// stageFlag is a flag configuring stages, see stageFlags.
+type stageFlag struct {
+	// set is whether the flag is given
+	set bool
+	// post is whether its stages run after the operation, rather than ahead
+	// of it
+	post bool
+	// build builds its stages
+	build func() (Pipeline, error)
+}
+
+// stageFlags lists the flags configuring stages, in the order their stages
+// run. The stages are built with the locale loc, and those gathering
+// statistics record them into stats, which may be nil.
+func (f *Flags) stageFlags(loc *Locale, stats *Stats) []stageFlag {
+	env := &Env{Locale: loc, Stats: stats, PluginTimeout: f.PluginTimeout}
+	return []stageFlag{
+		{f.Charmap != "", false, func() (Pipeline, error) {
+			c, err := f.charmap()
+			if err != nil {
+				return nil, err
+			}
+			return Pipeline{c.Decoder()}, nil
+		}},
+		{f.InputEncoding != "", false, func() (Pipeline, error) {
+			return pipeline(NewDecoder(f.InputEncoding))
+		}},
+		{f.Sanitize != "", false, func() (Pipeline, error) {
+			return pipeline(ParseSanitizer(f.Sanitize))
+		}},
+		{f.Normalize != "", false, func() (Pipeline, error) {
+			return pipeline(NewNormalizer(f.Normalize))
+		}},
+		{f.Fold, false, func() (Pipeline, error) {
+			return Pipeline{NewFolder(loc)}, nil
+		}},
+		{f.Escape != "", false, func() (Pipeline, error) {
+			return pipeline(NewEscaper(f.Escape, stats))
+		}},
+		{f.Script != "", false, func() (Pipeline, error) {
+			return f.script(env)
+		}},
+		{len(f.Stages) > 0, false, func() (Pipeline, error) {
+			return f.stageOps(env)
+		}},
+		{len(f.Plugins) > 0, false, f.plugins},
+		{f.Unescape != "", true, func() (Pipeline, error) {
+			return pipeline(NewUnescaper(f.Unescape))
+		}},
+		{f.Case != "", true, func() (Pipeline, error) {
+			return pipeline(NewCaser(f.Case, loc))
+		}},
+		{f.ToASCII, true, func() (Pipeline, error) {
+			return Pipeline{&Transliterator{
+				Replacement: f.ASCIIReplacement, Stats: stats}}, nil
+		}},
+		{f.NormalizeOutput != "", true, func() (Pipeline, error) {
+			return pipeline(NewNormalizer(f.NormalizeOutput))
+		}},
+		{f.Mask != "", true, func() (Pipeline, error) {
+			return pipeline(NewMasker(f.Mask, stats))
+		}},
+		{f.EOL != "", true, func() (Pipeline, error) {
+			return pipeline(ParseEOL(f.EOL))
+		}},
+		{f.OutputEncoding != "", true, func() (Pipeline, error) {
+			return pipeline(NewEncoder(f.OutputEncoding, f.Unencodable))
+		}},
+		{f.ShowNonPrinting != "", true, func() (Pipeline, error) {
+			return pipeline(ParseNonPrinting(f.ShowNonPrinting))
+		}},
+	}
+}
+
+// pipeline returns the pipeline of the single stage s, built with err.
+func pipeline(s Stage, err error) (Pipeline, error) {
+	if err != nil {
+		return nil, err
+	}
+	return Pipeline{s}, nil
+}
+
+// stageFlagsSet reports whether any flag configuring stages is given.
+func (f *Flags) stageFlagsSet() bool {
+	for _, s := range f.stageFlags(nil, nil) {
+		if s.set {
+			return true
+		}
+	}
+	return false
+}
+
+// stages builds the stages configured by the flags, split into those run
+// ahead of the operation configured on r and those run after it. Stages
+// gathering statistics record them into stats, which may be nil.
+func (f *Flags) stages(stats *Stats) (pre, post Pipeline, err error) {
+	if f == nil {
+		return nil, nil, nil
+	}
+	if f.Charmap != "" && f.InputEncoding != "" {
+		return nil, nil, fmt.Errorf("err: --charmap and --input-encoding " +
+			"are mutually exclusive")
+	}
+	loc, err := f.locale()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range f.stageFlags(loc, stats) {
+		if !s.set {
+			continue
+		}
+		p, err := s.build()
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.post {
+			post = append(post, p...)
+		} else {
+			pre = append(pre, p...)
+		}
+	}
+	return pre, post, nil
+}
+
+// Standalone reports whether the flags define work to do on their own, in
+// which case SET1 and SET2 may be left out. It's told from the flags alone,
+// without building any stage, which would start plugins and compile regexes
+// for nothing.
+func (f *Flags) Standalone() bool {
+	return f.Action != 0 || f.DetectEOL || f.Regex != "" || f.stageFlagsSet()
+}
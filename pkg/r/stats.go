@@ -0,0 +1,196 @@
+package r
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	StatsHuman = "human"
+	StatsJSON  = "json"
+)
+
+// Stats collects what happened to the input over a run. The counters are
+// updated by the operations themselves as they go, so reporting never needs
+// a second pass over the input.
+type Stats struct {
+	// BytesIn and BytesOut count the bytes read and written
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	// Lines counts the lines (or records) read
+	Lines int64 `json:"lines"`
+	// Translated, Deleted and Squeezed count the occurrences of each source
+	// character (or string) that got translated, deleted or squeezed away
+	Translated map[string]int64 `json:"translated"`
+	Deleted    map[string]int64 `json:"deleted"`
+	Squeezed   map[string]int64 `json:"squeezed"`
+	// SqueezeRuns counts the runs of repeated characters collapsed
+	SqueezeRuns int64 `json:"squeeze_runs"`
+	// Unmapped counts the characters --to-ascii couldn't transliterate
+	Unmapped map[string]int64 `json:"unmapped,omitempty"`
+	// Masked counts the spans --mask masked, by detector
+	Masked map[string]int64 `json:"masked,omitempty"`
+	// Invalid counts the bytes of invalid UTF-8 replaced with U+FFFD
+	Invalid int64 `json:"invalid,omitempty"`
+	// Elapsed is the wall time taken by the run
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// NewStats returns an empty Stats ready for use.
+func NewStats() *Stats {
+	return &Stats{
+		Translated: map[string]int64{},
+		Deleted:    map[string]int64{},
+		Squeezed:   map[string]int64{},
+		Unmapped:   map[string]int64{},
+		Masked:     map[string]int64{},
+	}
+}
+
+// charKey returns the key under which the byte b is counted. Bytes outside
+// of ASCII are written as \xNN, so the keys are always valid UTF-8.
+func charKey(b byte) string {
+	if b < 0x80 {
+		return string(rune(b))
+	}
+	return fmt.Sprintf("\\x%02x", b)
+}
+
+// The recording methods below are no-ops on a nil *Stats, so the operations
+// can call them unconditionally.
+
+func (s *Stats) translated(key string) {
+	if s != nil {
+		s.Translated[key]++
+	}
+}
+
+func (s *Stats) deleted(key string) {
+	if s != nil {
+		s.Deleted[key]++
+	}
+}
+
+func (s *Stats) squeezed(key string, newRun bool) {
+	if s == nil {
+		return
+	}
+	s.Squeezed[key]++
+	if newRun {
+		s.SqueezeRuns++
+	}
+}
+
+func (s *Stats) masked(detector string) {
+	if s != nil {
+		s.Masked[detector]++
+	}
+}
+
+func (s *Stats) invalid(n int) {
+	if s != nil {
+		s.Invalid += int64(n)
+	}
+}
+
+func (s *Stats) lines(n int) {
+	if s != nil {
+		s.Lines += int64(n)
+	}
+}
+
+// statsReader counts the bytes read through it into stats.
+type statsReader struct {
+	src   io.Reader
+	stats *Stats
+}
+
+func (sr *statsReader) Read(b []byte) (int, error) {
+	n, err := sr.src.Read(b)
+	sr.stats.BytesIn += int64(n)
+	return n, err
+}
+
+// statsWriter counts the bytes written through it into stats.
+type statsWriter struct {
+	dst   io.Writer
+	stats *Stats
+}
+
+func (sw *statsWriter) Write(b []byte) (int, error) {
+	n, err := sw.dst.Write(b)
+	sw.stats.BytesOut += int64(n)
+	return n, err
+}
+
+// Throughput returns the input processed per second, in bytes.
+func (s *Stats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.BytesIn) / s.Elapsed.Seconds()
+}
+
+// Report writes s to w, formatted either as StatsHuman or StatsJSON.
+func (s *Stats) Report(w io.Writer, format string) error {
+	switch format {
+	case StatsJSON:
+		return json.NewEncoder(w).Encode(struct {
+			*Stats
+			Throughput float64 `json:"throughput_bps"`
+		}{s, s.Throughput()})
+	case StatsHuman:
+		_, err := fmt.Fprintf(w, "bytes in:      %d\n"+
+			"bytes out:     %d\n"+
+			"lines:         %d\n"+
+			"translated:    %s\n"+
+			"deleted:       %s\n"+
+			"squeezed:      %s\n"+
+			"squeeze runs:  %d\n"+
+			"unmapped:      %s\n"+
+			"masked:        %s\n"+
+			"invalid utf-8: %d\n"+
+			"elapsed:       %s\n"+
+			"throughput:    %.2f MB/s\n",
+			s.BytesIn, s.BytesOut, s.Lines, countsString(s.Translated),
+			countsString(s.Deleted), countsString(s.Squeezed), s.SqueezeRuns,
+			countsString(s.Unmapped), countsString(s.Masked), s.Invalid,
+			s.Elapsed, s.Throughput()/1e6)
+		return err
+	}
+	return CheckStatsFormat(format)
+}
+
+// CheckStatsFormat returns an error if format is neither StatsHuman nor
+// StatsJSON.
+func CheckStatsFormat(format string) error {
+	if format == StatsHuman || format == StatsJSON {
+		return nil
+	}
+	return fmt.Errorf("err: unknown stats format %q. expecting %s or %s",
+		format, StatsHuman, StatsJSON)
+}
+
+// countsString formats counts as a sorted, comma separated list.
+func countsString(counts map[string]int64) string {
+	if len(counts) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%q %d", k, counts[k])
+	}
+	return sb.String()
+}
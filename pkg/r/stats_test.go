@@ -0,0 +1,75 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestStatsCounts(t *testing.T) {
+	test := []struct {
+		R                             R
+		RawString                     string
+		Translated, Deleted, Squeezed map[string]int64
+		SqueezeRuns, BytesOut, Lines  int64
+	}{
+		{R{From: []byte("a-c"), To: []byte("x-z")}, "abcabd\n",
+			map[string]int64{"a": 2, "b": 2, "c": 1}, nil, nil, 0, 7, 1},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+			DelString: "ab"}}, "abc\nba\n",
+			nil, map[string]int64{"a": 2, "b": 2}, nil, 0, 3, 2},
+		{R{FlagEnabled: true, Flag: &Flags{Action: Action_SQUEEZE,
+			SqueezeBytes: []byte("a")}}, "aaabaa\n",
+			nil, nil, map[string]int64{"a": 3}, 2, 4, 1},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &test[i].R
+		r.Stats = NewStats()
+		var out bytes.Buffer
+		err := r.Stream(context.Background(),
+			bytes.NewBufferString(test[i].RawString), &out)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for _, c := range []struct {
+			Got, Want map[string]int64
+		}{{r.Stats.Translated, test[i].Translated},
+			{r.Stats.Deleted, test[i].Deleted},
+			{r.Stats.Squeezed, test[i].Squeezed}} {
+			if len(c.Got) != len(c.Want) {
+				t.Errorf("%d: expected %v. got %v\n", i, c.Want, c.Got)
+			}
+			for k, v := range c.Want {
+				if c.Got[k] != v {
+					t.Errorf("%d: expected %v. got %v\n", i, c.Want, c.Got)
+				}
+			}
+		}
+		if r.Stats.BytesIn != int64(len(test[i].RawString)) ||
+			r.Stats.BytesOut != test[i].BytesOut ||
+			r.Stats.Lines != test[i].Lines ||
+			r.Stats.SqueezeRuns != test[i].SqueezeRuns {
+			t.Errorf("%d: unexpected totals %+v\n", i, r.Stats)
+		}
+	}
+}
+
+func TestStatsReport(t *testing.T) {
+	s := NewStats()
+	s.translated(charKey(0xff))
+	var out bytes.Buffer
+	if err := s.Report(&out, StatsJSON); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !json.Valid(out.Bytes()) {
+		t.Errorf("invalid JSON report: %s\n", out.String())
+	}
+	if err := s.Report(&out, "xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if CheckStatsFormat(StatsHuman) != nil || CheckStatsFormat(StatsJSON) != nil ||
+		CheckStatsFormat("a-z") == nil {
+		t.Errorf("unexpected result checking the formats")
+	}
+}
@@ -0,0 +1,229 @@
+package r
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Stream reads the input line by line (record by record in CSV mode) from
+// in, runs every addressed line through the operation configured on r and
+// writes the result to out. Only a single line is held in memory at any time,
+// so input of any size can be processed. The exception is --json, where the
+// document is read in whole. Stages configured by the flags run on the raw
+// input ahead of the operation, or on its output.
+func (r *R) Stream(ctx context.Context, in io.Reader, out io.Writer) error {
+	if r.Stats != nil {
+		defer func(start time.Time) {
+			r.Stats.Elapsed += time.Since(start)
+		}(time.Now())
+		in = &statsReader{src: in, stats: r.Stats}
+		out = &statsWriter{dst: out, stats: r.Stats}
+	}
+	pre, post, err := r.Flag.stages(r.Stats)
+	if err != nil {
+		return err
+	}
+	if m, ok := fuseCharmap(pre, post); ok && !r.operates() &&
+		r.Flag.Regex == "" {
+		// a plain code page conversion is done byte for byte
+		pre, post = Pipeline{m}, nil
+	}
+	if len(pre) > 0 {
+		in = &stageReader{src: in, p: pre}
+	}
+	if len(post) == 0 {
+		return r.stream(ctx, in, out)
+	}
+	sw := &stageWriter{dst: out, p: post}
+	if err = r.stream(ctx, in, sw); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// compile readies the operation configured on r to be applied line after
+// line, reporting any error in its sets up front. The locale, the engine and
+// what's compiled for it are resolved once and cached on r.
+func (r *R) compile() error {
+	if r.compiled {
+		return nil
+	}
+	loc, err := r.Flag.locale()
+	if err != nil {
+		return err
+	}
+	r.loc = loc
+	r.runes = r.runeMode()
+	if r.runes && r.runeOp == nil {
+		if r.runeOp, err = r.compileRunes(); err != nil {
+			return err
+		}
+	} else if !r.runes {
+		// the byte engine parses the sets with escapes line by line, so
+		// any error in them is reported up front
+		for _, spec := range r.specs() {
+			if escaped, _ := specEscapes(spec); escaped {
+				if _, err := parseByteSpec(spec); err != nil {
+					return err
+				}
+			}
+		}
+		if r.Flag.substitutes() {
+			if r.matcher, err = r.Flag.matcher(r.From); err != nil {
+				return err
+			}
+		}
+	}
+	r.compiled = true
+	return nil
+}
+
+// stream implements Stream, past the stages run ahead of and after the
+// operation.
+func (r *R) stream(ctx context.Context, in io.Reader, out io.Writer) error {
+	re, err := r.Flag.regex(r.Stats)
+	if err != nil {
+		return err
+	}
+	op := func(b []byte, _ position) []byte {
+		if re != nil {
+			b = re(b)
+		}
+		return r.Apply(ctx, b)
+	}
+	bw := bufio.NewWriter(out)
+	err = r.records(in, op, func(_ int, _, rec []byte) error {
+		_, err := bw.Write(rec)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// position maps an offset into a part of a record, as handed to the
+// operation, back to an offset into the record as read.
+type position func(off int) int
+
+// offsetAt returns the position of a part found at off into the record.
+func offsetAt(off int) position {
+	return func(n int) int {
+		return off + n
+	}
+}
+
+// shift returns the position of the part found n bytes into the part at.
+func (at position) shift(n int) position {
+	return func(off int) int {
+		return at(off + n)
+	}
+}
+
+// records reads in record by record, runs the lines, scopes and fields
+// addressed through op, the operation configured on r, and hands every
+// record to fn, along with the line of the input it starts on and the record
+// as read. Records not addressed are handed over as they are. op is told
+// where each part it's handed is found in the record.
+func (r *R) records(in io.Reader, op func(b []byte, at position) []byte,
+	fn func(first int, raw, rec []byte) error) error {
+	if err := r.compile(); err != nil {
+		return err
+	}
+	limit, err := r.Flag.limit()
+	if err != nil {
+		return err
+	}
+	r.limit = limit
+	scope, err := r.Flag.scope()
+	if err != nil {
+		return err
+	}
+	addr, err := r.Flag.address()
+	if err != nil {
+		return err
+	}
+	fields, err := r.Flag.fields()
+	if err != nil {
+		return err
+	}
+	apply := op
+	if scope != nil {
+		apply = func(b []byte, at position) []byte {
+			return scope.applyAt(b, at, op)
+		}
+	}
+	jsonMode, err := r.Flag.json()
+	if err != nil {
+		return err
+	}
+	process := func(b []byte) ([]byte, error) {
+		return apply(b, offsetAt(0)), nil
+	}
+	switch {
+	case fields != nil:
+		process = func(b []byte) ([]byte, error) {
+			return fields.applyAt(b, apply), nil
+		}
+	case jsonMode != nil && r.Flag.JSON:
+		// a JSON document isn't line oriented, so it's processed as a whole
+		if addr != nil {
+			return fmt.Errorf("err: --lines/--match/--not-match don't " +
+				"apply to --json")
+		}
+		doc, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		r.Stats.lines(bytes.Count(doc, []byte("\n")))
+		res, err := jsonMode.applyAt(doc, apply)
+		if err != nil {
+			return err
+		}
+		return fn(1, doc, res)
+	case jsonMode != nil:
+		process = func(b []byte) ([]byte, error) {
+			return jsonMode.applyAt(b, apply)
+		}
+	}
+	r.squeeze = nil
+	if scope == nil && fields == nil && jsonMode == nil {
+		// successive lines are whole, so a run of repeats goes on from one
+		// to the next
+		r.squeeze = newSqueezeRun()
+	}
+	br := bufio.NewReader(in)
+	// first is the line of the input each record starts on, which is what
+	// addresses it
+	for first := 1; ; {
+		raw, rerr := fields.readRecord(br, first)
+		if len(raw) > 0 {
+			r.Stats.lines(1)
+			rec := raw
+			// lines not addressed pass through as is
+			if addr == nil || addr.Selects(first, raw) {
+				r.limit.newLine()
+				if rec, err = process(raw); err != nil {
+					return fmt.Errorf("line %d: %w", first, err)
+				}
+			} else {
+				r.squeeze.reset()
+			}
+			if err = fn(first, raw, rec); err != nil {
+				return err
+			}
+			first += bytes.Count(raw, []byte("\n"))
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package r
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// substitutes reports whether the flags turn the translation into a string
+// substitution by ReplaceSlice, whatever SET1 looks like.
+func (f *Flags) substitutes() bool {
+	return f != nil && f.Action == 0 && (f.IgnoreCase || f.Word ||
+		f.PreserveCase)
+}
+
+// matcher finds the occurrences of SET1 for ReplaceSlice.
+type matcher struct {
+	from    []byte
+	pattern []rune
+	// fold compares characters under Unicode simple case folding
+	fold bool
+	// word, if set, holds the characters of words. An occurrence must not
+	// be preceded or followed by any of them.
+	word *RuneSet
+	// preserve maps the replacement into the case pattern of the occurrence
+	preserve bool
+	loc      *Locale
+}
+
+// matcher returns the matcher of from configured by the flags.
+func (f *Flags) matcher(from []byte) (*matcher, error) {
+	m := &matcher{from: from, pattern: []rune(string(from)), loc: CLocale}
+	if !f.substitutes() {
+		return m, nil
+	}
+	var err error
+	if m.loc, err = f.locale(); err != nil {
+		return nil, err
+	}
+	m.fold, m.preserve = f.IgnoreCase, f.PreserveCase
+	if f.Word {
+		if m.word, err = m.loc.ParseRuneSet("[:alnum:]_"); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// match returns the length of the occurrence starting at b[i], or 0 if
+// there's none.
+func (m *matcher) match(b []byte, i int) int {
+	n := 0
+	if !m.fold {
+		if !bytes.HasPrefix(b[i:], m.from) {
+			return 0
+		}
+		n = len(m.from)
+	} else {
+		for _, p := range m.pattern {
+			c, size := utf8.DecodeRune(b[i+n:])
+			if size == 0 || !foldEqual(c, p) {
+				return 0
+			}
+			n += size
+		}
+	}
+	if m.word != nil {
+		if c, size := utf8.DecodeLastRune(b[:i]); size > 0 && m.word.Has(c) {
+			return 0
+		}
+		if c, size := utf8.DecodeRune(b[i+n:]); size > 0 && m.word.Has(c) {
+			return 0
+		}
+	}
+	return n
+}
+
+// replacement returns to, in the case pattern of the occurrence if asked.
+func (m *matcher) replacement(occurrence, to []byte) []byte {
+	if !m.preserve {
+		return to
+	}
+	return matchCase(occurrence, to, m.loc)
+}
+
+// foldEqual reports whether a and b are equal under Unicode simple case
+// folding.
+func foldEqual(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCase returns to in the case pattern of orig: in upper case if orig
+// is (FOO), in lower case if orig is (foo), and with its first letter in
+// upper case if orig's is (Foo). Otherwise to is returned as it is.
+func matchCase(orig, to []byte, l *Locale) []byte {
+	upper, lower := false, false
+	for _, c := range string(orig) {
+		upper = upper || unicode.IsUpper(c)
+		lower = lower || unicode.IsLower(c)
+	}
+	first, _ := utf8.DecodeRune(orig)
+	switch {
+	case len(to) == 0:
+		return to
+	case upper && !lower:
+		return bytes.Map(l.ToUpper, to)
+	case lower && !upper:
+		return bytes.Map(l.ToLower, to)
+	case unicode.IsUpper(first):
+		c, size := utf8.DecodeRune(to)
+		return append(utf8.AppendRune(nil, l.ToUpper(c)), to[size:]...)
+	}
+	return to
+}
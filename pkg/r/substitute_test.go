@@ -0,0 +1,62 @@
+package r
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReplaceSliceOptions(t *testing.T) {
+	test := []struct {
+		Flags      Flags
+		From, To   string
+		RawString  string
+		DestString string
+	}{
+		{Flags{IgnoreCase: true}, "foo", "bar", "foo Foo FOO food",
+			"bar bar bar bard"},
+		// Unicode simple folding, where the Kelvin sign folds to k
+		{Flags{IgnoreCase: true}, "kelvin", "K", "Kelvin ΚELVIN", "K ΚELVIN"},
+		{Flags{IgnoreCase: true}, "σ", "s", "Σσς", "sss"},
+		{Flags{Word: true}, "cat", "dog", "cat catalog bobcat cat_1 (cat)",
+			"dog catalog bobcat cat_1 (dog)"},
+		{Flags{Word: true}, "a", "the", "a banana a", "the banana the"},
+		{Flags{Word: true, Locale: "fr_FR"}, "cat", "dog", "écat cat",
+			"écat dog"},
+		{Flags{IgnoreCase: true, PreserveCase: true}, "foo", "bar",
+			"foo Foo FOO fOO", "bar Bar BAR bar"},
+		{Flags{IgnoreCase: true, PreserveCase: true, Word: true}, "id",
+			"key", "ID Id id idle", "KEY Key key idle"},
+		// the case pattern is applied by the case mapping of the locale
+		{Flags{IgnoreCase: true, PreserveCase: true, Locale: "tr_TR"}, "kedi",
+			"istanbul", "KEDI", "İSTANBUL"},
+	}
+	for i := 0; i < len(test); i++ {
+		r := &R{From: []byte(test[i].From), To: []byte(test[i].To),
+			Flag: &test[i].Flags, RawString: test[i].RawString}
+		r.Churn(context.Background())
+		if r.DestString != test[i].DestString {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].DestString,
+				r.DestString)
+		}
+	}
+}
+
+func TestMatchCase(t *testing.T) {
+	test := []struct {
+		Orig, To, Expected string
+	}{
+		{"FOO", "bar", "BAR"},
+		{"foo", "Bar", "bar"},
+		{"Foo", "bar", "Bar"},
+		{"Foo", "barBaz", "BarBaz"},
+		{"fOo", "bar", "bar"},
+		{"42", "bar", "bar"},
+		{"Foo", "", ""},
+	}
+	for i := 0; i < len(test); i++ {
+		got := matchCase([]byte(test[i].Orig), []byte(test[i].To), CLocale)
+		if string(got) != test[i].Expected {
+			t.Errorf("%d: expected %q. got %q\n", i, test[i].Expected, got)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Command plugin is a stub plugin for the tests of r.Plugin. It speaks the
+// length-prefixed framing of r.Plugin, doing what its first argument says:
+//
+//	upper    answers every frame in upper case
+//	hold     answers every frame empty, and all of the input in the last one
+//	fail     exits 3 on the first frame, with a message on stderr
+//	hang     never answers
+//	garbage  answers with a header out of sync
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+func main() {
+	mode := "upper"
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	in := bufio.NewReader(os.Stdin)
+	out := bufio.NewWriter(os.Stdout)
+	var held []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(in, header[:]); err != nil {
+			fmt.Fprintln(os.Stderr, "plugin: input ended without a last frame")
+			os.Exit(1)
+		}
+		b := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(in, b); err != nil {
+			fmt.Fprintln(os.Stderr, "plugin: truncated frame")
+			os.Exit(1)
+		}
+		last := len(b) == 0
+		switch mode {
+		case "upper":
+			b = bytes.ToUpper(b)
+		case "hold":
+			held = append(held, b...)
+			b = nil
+			if last {
+				b = held
+			}
+		case "fail":
+			fmt.Fprintln(os.Stderr, "plugin: refusing to work")
+			os.Exit(3)
+		case "hang":
+			time.Sleep(time.Hour)
+		case "garbage":
+			out.WriteString("\xff\xff\xff\xff")
+			out.Flush()
+			continue
+		}
+		binary.BigEndian.PutUint32(header[:], uint32(len(b)))
+		out.Write(header[:])
+		out.Write(b)
+		out.Flush()
+		if last {
+			return
+		}
+	}
+}
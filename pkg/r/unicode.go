@@ -0,0 +1,112 @@
+package r
+
+import (
+	"fmt"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	CaseUpper = "upper"
+	CaseLower = "lower"
+	CaseTitle = "title"
+)
+
+// transformStage adapts a transform.Transformer to a Stage. Input the
+// transformer can't deal with until it sees more of it, like a combining
+// sequence split across chunks, is held back for the next chunk.
+type transformStage struct {
+	t    transform.Transformer
+	held []byte
+}
+
+// Process implements Stage.
+func (s *transformStage) Process(b []byte) []byte {
+	return s.run(b, false)
+}
+
+// Flush implements Stage.
+func (s *transformStage) Flush() []byte {
+	defer s.t.Reset()
+	return s.run(nil, true)
+}
+
+func (s *transformStage) run(b []byte, atEOF bool) []byte {
+	src := append(s.held, b...)
+	s.held = nil
+	dst := make([]byte, len(src)+len(src)/2+16)
+	var buffer []byte
+	for {
+		nDst, nSrc, err := s.t.Transform(dst, src, atEOF)
+		buffer = append(buffer, dst[:nDst]...)
+		src = src[nSrc:]
+		switch err {
+		case nil:
+			return buffer
+		case transform.ErrShortDst:
+			if nDst == 0 {
+				dst = make([]byte, 2*len(dst))
+			}
+		case transform.ErrShortSrc:
+			if atEOF {
+				return append(buffer, src...)
+			}
+			s.held = append(s.held, src...)
+			return buffer
+		default:
+			// input the transformer can't make sense of passes through as is
+			return append(buffer, src...)
+		}
+	}
+}
+
+// NewNormalizer returns a Stage normalising its input to the Unicode
+// normalization form, one of nfc, nfd, nfkc or nfkd.
+func NewNormalizer(form string) (Stage, error) {
+	forms := map[string]norm.Form{
+		"nfc":  norm.NFC,
+		"nfd":  norm.NFD,
+		"nfkc": norm.NFKC,
+		"nfkd": norm.NFKD,
+	}
+	f, ok := forms[form]
+	if !ok {
+		return nil, fmt.Errorf("err: unknown normalization form %q. "+
+			"expecting nfc, nfd, nfkc or nfkd", form)
+	}
+	return &transformStage{t: f}, nil
+}
+
+// NewFolder returns a Stage applying full Unicode case folding to its input,
+// so eg: ß and SS both fold to ss. In Turkic locales, I folds to dotless ı
+// and İ to i.
+func NewFolder(l *Locale) Stage {
+	if l.turkic() {
+		return &transformStage{t: transform.Chain(cases.Lower(l.Tag),
+			cases.Fold())}
+	}
+	return &transformStage{t: cases.Fold()}
+}
+
+// NewCaser returns a Stage mapping its input to upper, lower or title case,
+// following the rules of l. Unlike a per-rune translation, it handles
+// mappings changing the length of the text, such as ß to SS.
+func NewCaser(mapping string, l *Locale) (Stage, error) {
+	tag := language.Und
+	if !l.IsC() {
+		tag = l.Tag
+	}
+	switch mapping {
+	case CaseUpper:
+		return &transformStage{t: cases.Upper(tag)}, nil
+	case CaseLower:
+		return &transformStage{t: cases.Lower(tag)}, nil
+	case CaseTitle:
+		return &transformStage{t: cases.Title(tag)}, nil
+	}
+	return nil, fmt.Errorf("err: unknown case mapping %q. expecting %s, %s "+
+		"or %s", mapping, CaseUpper, CaseLower, CaseTitle)
+}
@@ -0,0 +1,93 @@
+package r
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// runStage feeds raw through s in chunks of size bytes.
+func runStage(s Stage, raw []byte, size int) []byte {
+	var got []byte
+	for i := 0; i < len(raw); i += size {
+		end := i + size
+		if end > len(raw) {
+			end = len(raw)
+		}
+		got = append(got, s.Process(raw[i:end])...)
+	}
+	return append(got, s.Flush()...)
+}
+
+func TestNormalizer(t *testing.T) {
+	test := []struct {
+		Form       string
+		RawString  string
+		DestString string
+	}{
+		{"nfc", "é", "é"},
+		{"nfd", "é", "é"},
+		{"nfkc", "ﬁ", "fi"},
+		{"nfkd", "½", "1⁄2"},
+		{"nfc", "plain", "plain"},
+	}
+	for i := 0; i < len(test); i++ {
+		for _, size := range []int{1, 64} {
+			s, err := NewNormalizer(test[i].Form)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := runStage(s, []byte(test[i].RawString), size)
+			if string(got) != test[i].DestString {
+				t.Errorf("%s/%d: expected %q. got %q\n", test[i].Form, size,
+					test[i].DestString, got)
+			}
+		}
+	}
+	if _, err := NewNormalizer("nfx"); err == nil {
+		t.Errorf("expected error for unknown form")
+	}
+}
+
+func TestCaser(t *testing.T) {
+	test := []struct {
+		Mapping    string
+		RawString  string
+		DestString string
+	}{
+		{CaseUpper, "straße", "STRASSE"},
+		{CaseLower, "ÉCOLE", "école"},
+		{CaseTitle, "hello wORLD", "Hello World"},
+	}
+	for i := 0; i < len(test); i++ {
+		s, err := NewCaser(test[i].Mapping, CLocale)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got := runStage(s, []byte(test[i].RawString), 1)
+		if string(got) != test[i].DestString {
+			t.Errorf("%s: expected %q. got %q\n", test[i].Mapping,
+				test[i].DestString, got)
+		}
+	}
+	if got := runStage(NewFolder(CLocale), []byte("Straße STRASSE"), 3); string(got) !=
+		"strasse strasse" {
+		t.Errorf("expected %q. got %q\n", "strasse strasse", got)
+	}
+}
+
+func TestStreamNormalizeFold(t *testing.T) {
+	// once decomposed and folded, both É and é are made up of e and a
+	// combining acute accent, which are then deleted
+	r := R{FlagEnabled: true, Flag: &Flags{Action: Action_DELETE,
+		DelString: "e\u0301", Normalize: "nfd", Fold: true}}
+	var out bytes.Buffer
+	err := r.Stream(context.Background(),
+		bytes.NewBufferString("CAF\u00c9 caf\u00e9\n"), &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "caf caf\n" {
+		t.Errorf("expected %q. got %q\n", "caf caf\n", out.String())
+	}
+}
@@ -0,0 +1,282 @@
+package w
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	opEqual = iota
+	opDelete
+	opInsert
+)
+
+// DiffContext is the number of unchanged lines shown around each hunk
+var DiffContext = 3
+
+// DiffMaxEdits bounds the work done by diff. Past this many edits it gives up
+// looking for the shortest edit script and replaces everything instead.
+var DiffMaxEdits = 1024
+
+// op is a single step of an edit script turning a into b: keep a[A] (which
+// equals b[B]), delete a[A] or insert b[B].
+type op struct {
+	Kind int
+	A, B int
+}
+
+// diff computes the shortest edit script turning a into b, using Myers'
+// O(ND) algorithm.
+func diff[T comparable](a, b []T) []op {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	// trace holds, for every d, the part of v reachable in d edits as it was
+	// before step d
+	var trace [][]int
+	for d := 0; d <= max && d <= DiffMaxEdits; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	return replaceAll(n, m)
+}
+
+// backtrack walks the trace of diff back from (n, m) to recover the edit
+// script.
+func backtrack(trace [][]int, n, m int) []op {
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// trace[d] starts at k = -d-1
+		v := func(k int) int {
+			return trace[d][k+d+1]
+		}
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, op{opEqual, x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, op{opInsert, x, prevY})
+			} else {
+				ops = append(ops, op{opDelete, prevX, y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceAll returns the edit script deleting all n elements of a and
+// inserting all m elements of b.
+func replaceAll(n, m int) []op {
+	ops := make([]op, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, op{opDelete, i, 0})
+	}
+	for j := 0; j < m; j++ {
+		ops = append(ops, op{opInsert, n, j})
+	}
+	return ops
+}
+
+// diffLines computes the edit script turning the lines al into bl. As tr
+// works line by line, the same number of lines on both sides means line i of
+// al became line i of bl, which saves searching for the shortest script.
+func diffLines(al, bl []string) []op {
+	if len(al) != len(bl) {
+		return diff(al, bl)
+	}
+	var ops []op
+	for i := range al {
+		if al[i] == bl[i] {
+			ops = append(ops, op{opEqual, i, i})
+		} else {
+			ops = append(ops, op{opDelete, i, i}, op{opInsert, i + 1, i})
+		}
+	}
+	return ops
+}
+
+// splitLines splits b into lines, each keeping its terminating newline.
+func splitLines(b []byte) []string {
+	var lines []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		lines = append(lines, string(b[:i]))
+		b = b[i:]
+	}
+	return lines
+}
+
+// UnifiedDiff writes the differences between the original content a and the
+// processed content b of the file name to out, in unified diff format.
+// Nothing is written if a and b are the same.
+func UnifiedDiff(out io.Writer, name string, a, b []byte) error {
+	al, bl := splitLines(a), splitLines(b)
+	ops := diffLines(al, bl)
+	var sb strings.Builder
+	for start := 0; start < len(ops); {
+		// find the next change, and the end of the hunk holding it
+		for start < len(ops) && ops[start].Kind == opEqual {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		end, lastChange := start, start
+		for end < len(ops) && (ops[end].Kind != opEqual ||
+			end-lastChange <= 2*DiffContext) {
+			if ops[end].Kind != opEqual {
+				lastChange = end
+			}
+			end++
+		}
+		end = lastChange + 1
+		first := start - DiffContext
+		if first < 0 {
+			first = 0
+		}
+		if end += DiffContext; end > len(ops) {
+			end = len(ops)
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", name, name)
+		}
+		writeHunk(&sb, ops[first:end], al, bl)
+		start = end
+	}
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+// writeHunk writes a single hunk covering ops to sb.
+func writeHunk(sb *strings.Builder, ops []op, al, bl []string) {
+	var aCount, bCount int
+	for _, o := range ops {
+		if o.Kind != opInsert {
+			aCount++
+		}
+		if o.Kind != opDelete {
+			bCount++
+		}
+	}
+	aStart, bStart := ops[0].A+1, ops[0].B+1
+	if aCount == 0 {
+		aStart--
+	}
+	if bCount == 0 {
+		bStart--
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, o := range ops {
+		var prefix, line string
+		switch o.Kind {
+		case opEqual:
+			prefix, line = " ", al[o.A]
+		case opDelete:
+			prefix, line = "-", al[o.A]
+		case opInsert:
+			prefix, line = "+", bl[o.B]
+		}
+		sb.WriteString(prefix + line)
+		if !strings.HasSuffix(line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+const (
+	colorChanged = "\x1b[32m"
+	colorDeleted = "\x1b[9;31m"
+	colorReset   = "\x1b[0m"
+)
+
+// Highlight writes the processed content b to out. When color is set,
+// characters that changed from the original content a are coloured green,
+// and characters deleted from it are shown struck through in red.
+func Highlight(out io.Writer, a, b []byte, color bool) error {
+	if !color {
+		_, err := out.Write(b)
+		return err
+	}
+	al, bl := splitLines(a), splitLines(b)
+	ops := diffLines(al, bl)
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == opEqual {
+			sb.WriteString(al[ops[i].A])
+			i++
+			continue
+		}
+		var deleted, inserted string
+		for ; i < len(ops) && ops[i].Kind == opDelete; i++ {
+			deleted += al[ops[i].A]
+		}
+		for ; i < len(ops) && ops[i].Kind == opInsert; i++ {
+			inserted += bl[ops[i].B]
+		}
+		highlightRunes(&sb, []rune(deleted), []rune(inserted))
+	}
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+// highlightRunes writes br to sb, marking up how it differs from ar.
+func highlightRunes(sb *strings.Builder, ar, br []rune) {
+	ops := diff(ar, br)
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == opEqual {
+			sb.WriteRune(ar[ops[i].A])
+			i++
+			continue
+		}
+		// a run of deletions followed by insertions is a change, a run of
+		// deletions on its own is a deletion
+		var deleted, inserted []rune
+		for ; i < len(ops) && ops[i].Kind == opDelete; i++ {
+			deleted = append(deleted, ar[ops[i].A])
+		}
+		for ; i < len(ops) && ops[i].Kind == opInsert; i++ {
+			inserted = append(inserted, br[ops[i].B])
+		}
+		if len(inserted) > 0 {
+			sb.WriteString(colorChanged + string(inserted) + colorReset)
+		} else {
+			sb.WriteString(colorDeleted + string(deleted) + colorReset)
+		}
+	}
+}
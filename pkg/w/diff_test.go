@@ -0,0 +1,83 @@
+package w
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	test := []struct {
+		A, B string
+	}{
+		{"abcabba", "cbabac"},
+		{"", "abc"},
+		{"abc", ""},
+		{"same", "same"},
+	}
+	for i := 0; i < len(test); i++ {
+		a, b := []rune(test[i].A), []rune(test[i].B)
+		var got []rune
+		for _, o := range diff(a, b) {
+			switch o.Kind {
+			case opEqual:
+				if a[o.A] != b[o.B] {
+					t.Errorf("%d: equal op on %c and %c\n", i, a[o.A], b[o.B])
+				}
+				got = append(got, a[o.A])
+			case opInsert:
+				got = append(got, b[o.B])
+			}
+		}
+		if string(got) != test[i].B {
+			t.Errorf("expected %s. got %s\n", test[i].B, string(got))
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	var out bytes.Buffer
+	a := "1\n2\n3\n4\nab\n5\n6\n7\n8\n9\n10\n"
+	b := "1\n2\n3\n4\nAB\n5\n6\n7\n8\n9\n10\n"
+	if err := UnifiedDiff(&out, "f", []byte(a), []byte(b)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "--- f\n+++ f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-ab\n+AB\n 5\n 6\n 7\n"
+	if out.String() != want {
+		t.Errorf("expected %q. got %q\n", want, out.String())
+	}
+	out.Reset()
+	if err := UnifiedDiff(&out, "f", []byte(a), []byte(a)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output. got %q\n", out.String())
+	}
+	out.Reset()
+	if err := UnifiedDiff(&out, "f", []byte("a\nb\nc"), []byte("abc")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want = "--- f\n+++ f\n@@ -1,3 +1,1 @@\n-a\n-b\n-c\n\\ No newline at end of file\n" +
+		"+abc\n\\ No newline at end of file\n"
+	if out.String() != want {
+		t.Errorf("expected %q. got %q\n", want, out.String())
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	var out bytes.Buffer
+	if err := Highlight(&out, []byte("abc\nxy\n"), []byte("aBc\ny\n"),
+		true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "a" + colorChanged + "B" + colorReset + "c\n" + colorDeleted + "x" +
+		colorReset + "y\n"
+	if out.String() != want {
+		t.Errorf("expected %q. got %q\n", want, out.String())
+	}
+	out.Reset()
+	Highlight(&out, []byte("abc"), []byte("aBc"), false)
+	if strings.Contains(out.String(), "\x1b") {
+		t.Errorf("expected no colour. got %q\n", out.String())
+	}
+}